@@ -8,9 +8,14 @@ import (
 	"os/signal"
 	"sync"
 	"time"
+
+	"github.com/jmileson/scratch/clock"
 )
 
 var (
+	// clk is used for all the waiting, tests replace it with a fake
+	clk clock.Clock = clock.New()
+
 	errFinalize    = errors.New("didn't finalize")
 	finalizerFuncs = []finalizeFunc{
 		finalizeFast,
@@ -27,7 +32,7 @@ func simulateWork(ctx context.Context, name string, delay int, doneFuncs chan<-
 	select {
 	case <-ctx.Done():
 		return
-	case <-time.After(time.Duration(delay) * time.Millisecond):
+	case <-clk.After(time.Duration(delay) * time.Millisecond):
 		// This branch simulates the "work" finishing before the context is cancelled
 	}
 
@@ -81,7 +86,7 @@ func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
 	case <-done:
 		// wg completed normally
 		return false
-	case <-time.After(timeout):
+	case <-clk.After(timeout):
 		return true
 	}
 }
@@ -105,9 +110,9 @@ func handleSig(ctx context.Context, sig <-chan os.Signal, done chan<- bool, done
 	t, ok := ctx.Deadline()
 	if !ok {
 		// just choose something, this should never happen
-		t = time.Now().Add(5 * time.Second)
+		t = clk.Now().Add(5 * time.Second)
 	}
-	timeout := time.Until(t)
+	timeout := t.Sub(clk.Now())
 	waitTimeout(&wg, timeout)
 
 	// close the channel to indicate that we won't send any more
@@ -196,7 +201,7 @@ func main() {
 			fmt.Println("but we should check for errors!")
 			checkErrors(errors)
 			return
-		case <-time.After(1 * time.Second):
+		case <-clk.After(1 * time.Second):
 			// this is just a sleep to simulate work happening on the main thread,
 			// e.g. an HTTP server or some other long running process.
 		}
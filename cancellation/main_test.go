@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+// useFakeClock replaces clk with a fake for the duration of the test.
+func useFakeClock(t *testing.T) *clock.Fake {
+	fake := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	old := clk
+	clk = fake
+	t.Cleanup(func() { clk = old })
+
+	return fake
+}
+
+func TestSimulateWorkCompletes(t *testing.T) {
+	assert := assert.New(t)
+	fake := useFakeClock(t)
+
+	doneFuncs := make(chan string, 1)
+	errs := make(chan error, 1)
+	go simulateWork(context.Background(), "slow", 3000, doneFuncs, errs)
+
+	fake.BlockUntil(1)
+	fake.Advance(3 * time.Second)
+
+	assert.Equal("slow", <-doneFuncs)
+	assert.ErrorIs(<-errs, errFinalize)
+}
+
+func TestSimulateWorkCancelled(t *testing.T) {
+	assert := assert.New(t)
+	fake := useFakeClock(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	doneFuncs := make(chan string, 1)
+	errs := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		simulateWork(ctx, "never", 100000, doneFuncs, errs)
+	}()
+
+	fake.BlockUntil(1)
+	cancel()
+	<-finished
+
+	assert.Empty(doneFuncs)
+	assert.Empty(errs)
+}
+
+func TestWaitTimeout(t *testing.T) {
+	assert := assert.New(t)
+	fake := useFakeClock(t)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	timedOut := make(chan bool)
+	go func() {
+		timedOut <- waitTimeout(&wg, time.Second)
+	}()
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	assert.True(<-timedOut)
+
+	go func() {
+		timedOut <- waitTimeout(&wg, time.Second)
+	}()
+
+	fake.BlockUntil(1)
+	wg.Done()
+	assert.False(<-timedOut)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Unclassified is the class given to errors that don't match any registered rule.
+const Unclassified = "unclassified error"
+
+// DefaultSamples is how many messages are kept per class when
+// a Classifier is created with a sample size of zero or less.
+const DefaultSamples = 3
+
+type rule struct {
+	class string
+	match func(error) bool
+}
+
+// Classifier sorts errors into named classes using errors.Is and errors.As,
+// so wrapped errors land in the same class as the errors they wrap.
+// Rules are checked in the order they were registered, first match wins.
+type Classifier struct {
+	rules   []rule
+	samples int
+}
+
+// NewClassifier creates a Classifier that keeps up to samples
+// messages for each class it sees.
+func NewClassifier(samples int) *Classifier {
+	if samples <= 0 {
+		samples = DefaultSamples
+	}
+
+	return &Classifier{samples: samples}
+}
+
+// RegisterSentinel puts any error matching target with errors.Is into class.
+func (c *Classifier) RegisterSentinel(class string, target error) {
+	c.rules = append(c.rules, rule{
+		class: class,
+		match: func(err error) bool {
+			return errors.Is(err, target)
+		},
+	})
+}
+
+// RegisterType puts any error that errors.As can convert to E into class.
+// It's a function rather than a method because methods can't take type parameters.
+func RegisterType[E error](c *Classifier, class string) {
+	c.rules = append(c.rules, rule{
+		class: class,
+		match: func(err error) bool {
+			var target E
+			return errors.As(err, &target)
+		},
+	})
+}
+
+// Classify returns the class of err, or Unclassified.
+func (c *Classifier) Classify(err error) string {
+	for _, r := range c.rules {
+		if r.match(err) {
+			return r.class
+		}
+	}
+
+	return Unclassified
+}
+
+// Groups creates an empty set of groups that classifies errors with c.
+func (c *Classifier) Groups() *ErrorGroups {
+	return &ErrorGroups{
+		classifier: c,
+		groups:     make(map[string]*ClassSummary),
+	}
+}
+
+// ClassSummary describes the errors seen for a single class.
+type ClassSummary struct {
+	// Class is the name the errors were registered under.
+	Class string
+
+	// Count is how many errors of this class were seen.
+	Count int
+
+	// Samples holds the messages of the first few errors seen.
+	Samples []string
+}
+
+// Summary is the grouped errors, largest class first.
+type Summary []ClassSummary
+
+// Total is the number of errors across all classes.
+func (s Summary) Total() int {
+	var total int
+	for _, c := range s {
+		total += c.Count
+	}
+
+	return total
+}
+
+// String reads like "7 timeouts, 3 validation errors".
+func (s Summary) String() string {
+	if len(s) == 0 {
+		return "no errors"
+	}
+
+	parts := make([]string, 0, len(s))
+	for _, c := range s {
+		class := c.Class
+		if c.Count != 1 {
+			class += "s"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", c.Count, class))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// ErrorGroups tallies errors by class. It's safe to share
+// between consumers.
+type ErrorGroups struct {
+	classifier *Classifier
+
+	mu     sync.Mutex
+	groups map[string]*ClassSummary
+}
+
+// Add classifies err and records it against its class.
+func (g *ErrorGroups) Add(err error) {
+	class := g.classifier.Classify(err)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	group, ok := g.groups[class]
+	if !ok {
+		group = &ClassSummary{Class: class}
+		g.groups[class] = group
+	}
+
+	group.Count++
+	if len(group.Samples) < g.classifier.samples {
+		group.Samples = append(group.Samples, err.Error())
+	}
+}
+
+// Consume implements ConsumerFunc, so the groups can be handed straight
+// to a Service that carries errors. It never fails.
+func (g *ErrorGroups) Consume(_ context.Context, err error) error {
+	g.Add(err)
+	return nil
+}
+
+// Summary returns a snapshot of the groups, ordered by count
+// and then by class name so the output is stable.
+func (g *ErrorGroups) Summary() Summary {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	summary := make(Summary, 0, len(g.groups))
+	for _, group := range g.groups {
+		c := *group
+		c.Samples = append([]string(nil), group.Samples...)
+		summary = append(summary, c)
+	}
+
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Count != summary[j].Count {
+			return summary[i].Count > summary[j].Count
+		}
+		return summary[i].Class < summary[j].Class
+	})
+
+	return summary
+}
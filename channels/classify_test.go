@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewClassifier(0)
+	c.RegisterSentinel("timeout", context.DeadlineExceeded)
+	RegisterType[*validationError](c, "validation error")
+
+	assert.Equal("timeout", c.Classify(context.DeadlineExceeded))
+	assert.Equal("timeout", c.Classify(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	assert.Equal("validation error", c.Classify(fmt.Errorf("wrapped: %w", &validationError{"foo"})))
+	assert.Equal(Unclassified, c.Classify(errors.New("something else")))
+}
+
+func TestClassifyFirstMatchWins(t *testing.T) {
+	c := NewClassifier(0)
+	c.RegisterSentinel("first", context.Canceled)
+	c.RegisterSentinel("second", context.Canceled)
+
+	assert.Equal(t, "first", c.Classify(context.Canceled))
+}
+
+func TestErrorGroupsSummary(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewClassifier(2)
+	c.RegisterSentinel("timeout", context.DeadlineExceeded)
+	RegisterType[*validationError](c, "validation error")
+
+	groups := c.Groups()
+	for i := 0; i < 7; i++ {
+		groups.Add(fmt.Errorf("call %d: %w", i, context.DeadlineExceeded))
+	}
+	for i := 0; i < 3; i++ {
+		groups.Add(&validationError{field: fmt.Sprint("field", i)})
+	}
+	groups.Add(errors.New("mystery"))
+
+	summary := groups.Summary()
+
+	assert.Equal("7 timeouts, 3 validation errors, 1 unclassified error", summary.String())
+	assert.Equal(11, summary.Total())
+	assert.Equal([]string{
+		"call 0: context deadline exceeded",
+		"call 1: context deadline exceeded",
+	}, summary[0].Samples)
+	assert.Equal([]string{"mystery"}, summary[2].Samples)
+}
+
+func TestErrorGroupsAsConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewClassifier(0)
+	c.RegisterSentinel("timeout", context.DeadlineExceeded)
+	RegisterType[*validationError](c, "validation error")
+	groups := c.Groups()
+
+	s := NewService(context.Background(), producer, groups.Consume, WithConsumers(3))
+	s.StartProducer()
+	s.StartConsumer()
+	res := s.Done()
+
+	assert.Equal(5, res.Count)
+	assert.Empty(res.Errs)
+	assert.Equal("3 validation errors, 2 timeouts", groups.Summary().String())
+}
+
+func TestEmptySummary(t *testing.T) {
+	assert.Equal(t, "no errors", NewClassifier(0).Groups().Summary().String())
+}
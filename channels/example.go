@@ -1,60 +1,410 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
 
-type Service struct {
-	resultChan chan error
-	closed     chan int
+// ErrStopped is the cause reported when a Service is stopped
+// by its owner before the producer finished.
+var ErrStopped = errors.New("service stopped")
+
+// ProducerFunc publishes items to out until it runs out of work
+// or ctx is cancelled. The Service closes out when it returns,
+// so producers must not close it themselves.
+type ProducerFunc[T any] func(ctx context.Context, out chan<- T) error
+
+// ConsumerFunc handles a single item. A returned error is recorded
+// in the Result, but doesn't stop the Service.
+type ConsumerFunc[T any] func(ctx context.Context, item T) error
+
+// PanicError wraps a value recovered from a panicking
+// producer or consumer.
+type PanicError struct {
+	// Value is whatever was passed to panic.
+	Value any
+
+	// Stack is the stack trace captured at the time of the panic.
+	Stack []byte
+}
+
+// Error implements error interface for PanicError.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("recovered from panic: %v", e.Value)
+}
+
+// Unwrap allows errors.Is/As to see through panics
+// that were raised with an error value.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Tally is what a single consumer saw during a run.
+type Tally struct {
+	// Count is the number of items the consumer handled.
+	Count int
+
+	// Errs holds errors returned by the consumer, along with
+	// a recovered panic if it had one.
+	Errs []error
+}
+
+// Result summarizes a completed run of a Service.
+type Result struct {
+	// Count is the number of items handled across all consumers.
+	Count int
+
+	// Errs holds errors returned by the producer and consumers,
+	// along with any recovered panics. Consumer errors come first, grouped by
+	// consumer, or in the order the producer published the items that caused
+	// them when WithOrderedResults is set. Producer errors come last.
+	//
+	// Which consumer gets which item is a race, so only WithOrderedResults
+	// gives the same order from one run to the next.
+	Errs []error
+
+	// Consumers holds each consumer's own tally, indexed by the order
+	// the consumers were started in.
+	Consumers []Tally
+
+	// Cause is why the Service terminated: nil if the producer ran out of
+	// work and everything was consumed, otherwise the reason it was cut short
+	// (ErrStopped, a *PanicError, a producer error, or the parent context's cause).
+	Cause error
+}
+
+// Option configures a Service.
+type Option func(*config)
+
+type config struct {
+	consumers int
+	ordered   bool
+}
+
+// WithConsumers sets how many consumers share the work, the default is 1.
+func WithConsumers(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.consumers = n
+		}
+	}
+}
+
+// WithOrderedResults orders the aggregated consumer errors by the order
+// the producer published the items, rather than grouping them by consumer.
+func WithOrderedResults() Option {
+	return func(c *config) {
+		c.ordered = true
+	}
+}
+
+// sequenced tags an item with its position in the producer's output
+// so results can be put back in order after the consumers race for them.
+type sequenced[T any] struct {
+	seq  uint64
+	item T
+}
+
+type sequencedErr struct {
+	seq uint64
+	err error
+}
+
+// tally is owned by a single consumer goroutine, so it doesn't
+// need a lock. It's only read by Done once every consumer has finished.
+type tally struct {
+	count int
+	errs  []sequencedErr
+}
+
+// Service runs a producer and a set of consumers connected
+// by a channel, and tracks them until they've all finished.
+type Service[T any] struct {
+	produce ProducerFunc[T]
+	consume ConsumerFunc[T]
+	cfg     config
+
+	items  chan sequenced[T]
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
+	tallies []*tally
+
+	// mu guards errs, which holds producer errors, running, the number
+	// of producer and consumer goroutines still going, and cause.
+	mu      sync.Mutex
+	errs    []error
+	running int
+	cause   error
+
+	once   sync.Once
+	result Result
+}
+
+// NewService creates a Service that runs until produce finishes,
+// ctx is cancelled, or Stop is called.
+func NewService[T any](ctx context.Context, produce ProducerFunc[T], consume ConsumerFunc[T], opts ...Option) *Service[T] {
+	cfg := config{consumers: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	return &Service[T]{
+		produce: produce,
+		consume: consume,
+		cfg:     cfg,
+		items:   make(chan sequenced[T]),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// StartProducer runs the producer in the background.
+func (s *Service[T]) StartProducer() {
+	s.start()
+	go s.runProducer()
+}
+
+// StartConsumer runs the configured number of consumers in the background.
+func (s *Service[T]) StartConsumer() {
+	for i := 0; i < s.cfg.consumers; i++ {
+		t := &tally{}
+		s.tallies = append(s.tallies, t)
+
+		s.start()
+		go s.runConsumer(t)
+	}
+}
+
+// Stop asks the producer and consumers to wind down. It doesn't wait
+// for them to finish, use Done for that.
+func (s *Service[T]) Stop() {
+	s.cancel(ErrStopped)
+}
+
+// Done blocks until the producer and consumers have all
+// finished and reports what happened. It's safe to call more than once.
+func (s *Service[T]) Done() Result {
+	s.wg.Wait()
+
+	s.once.Do(func() {
+		s.result = s.merge()
+
+		s.mu.Lock()
+		s.result.Cause = s.cause
+		s.mu.Unlock()
+
+		s.cancel(nil)
+	})
+
+	res := s.result
+	res.Errs = append([]error(nil), s.result.Errs...)
+	res.Consumers = append([]Tally(nil), s.result.Consumers...)
+
+	return res
 }
 
-func (s *Service) StartProducer() {
-	go producer(s.resultChan)
+// merge combines the consumer tallies into a single Result.
+// Consumer errors are gathered in start order, but the items each
+// consumer got, and so their errors, depend on how the goroutines
+// were scheduled. Only sorting by seq makes the order deterministic.
+func (s *Service[T]) merge() Result {
+	var res Result
+	var errs []sequencedErr
+
+	for _, t := range s.tallies {
+		consumer := Tally{Count: t.count}
+		for _, e := range t.errs {
+			consumer.Errs = append(consumer.Errs, e.err)
+		}
+
+		res.Consumers = append(res.Consumers, consumer)
+		res.Count += t.count
+		errs = append(errs, t.errs...)
+	}
+
+	if s.cfg.ordered {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].seq < errs[j].seq
+		})
+	}
+
+	for _, e := range errs {
+		res.Errs = append(res.Errs, e.err)
+	}
+
+	s.mu.Lock()
+	res.Errs = append(res.Errs, s.errs...)
+	s.mu.Unlock()
+
+	return res
 }
 
-func (s *Service) StartConsumer() {
-	go consumer(s.resultChan, s.closed)
+// start counts a producer or consumer goroutine that's about to run.
+func (s *Service[T]) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running++
+	s.wg.Add(1)
 }
 
-func (s *Service) Done() {
-	cnt := <-s.closed
-	fmt.Printf("handled %d errors\n", cnt)
+// finish counts a producer or consumer goroutine out. The last one
+// takes the cause, so a Stop or cancellation that comes after the run
+// is over, but before Done, doesn't make it look cut short. It must
+// be deferred first, so it runs after everything else.
+func (s *Service[T]) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+	if s.running == 0 {
+		s.cause = context.Cause(s.ctx)
+	}
+	s.wg.Done()
 }
 
-func NewService() Service {
-	return Service{
-		resultChan: make(chan error),
-		closed:     make(chan int, 1),
+func (s *Service[T]) runProducer() {
+	defer s.finish()
+	// closing here rather than in the producer means the consumers
+	// are always released, even if the producer panics.
+	defer close(s.items)
+
+	raw := make(chan T)
+	go func() {
+		defer close(raw)
+		defer s.capturePanic(s.record)
+
+		err := s.produce(s.ctx, raw)
+		// a producer that gives up because we cancelled it isn't a failure,
+		// the cause is already recorded on the context.
+		if err != nil && !errors.Is(err, s.ctx.Err()) {
+			s.record(err)
+			s.cancel(err)
+		}
+	}()
+
+	// number items on their way out so the consumers' results can
+	// be put back in the producer's order.
+	var seq uint64
+	for item := range raw {
+		select {
+		case s.items <- sequenced[T]{seq: seq, item: item}:
+			seq++
+		case <-s.ctx.Done():
+			// nobody may be left to take the item. Let the producer
+			// see the cancellation and finish, rather than block on raw.
+			for range raw {
+			}
+			return
+		}
 	}
 }
 
-func producer(ch chan<- error) {
+func (s *Service[T]) runConsumer(t *tally) {
+	defer s.finish()
+	// if a consumer dies the producer may be blocked on a send,
+	// keep draining until it notices the cancellation and closes the channel.
+	defer func() {
+		for range s.items {
+		}
+	}()
+
+	var current uint64
+	defer s.capturePanic(func(err error) {
+		t.errs = append(t.errs, sequencedErr{current, err})
+	})
+
+	for next := range s.items {
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		current = next.seq
+		err := s.consume(s.ctx, next.item)
+		t.count++
+
+		if err != nil {
+			t.errs = append(t.errs, sequencedErr{current, err})
+		}
+	}
+}
+
+// capturePanic converts a panic in the calling goroutine into an error,
+// hands it to record and cancels the Service. Must be called directly by defer.
+func (s *Service[T]) capturePanic(record func(error)) {
+	if v := recover(); v != nil {
+		err := &PanicError{Value: v, Stack: debug.Stack()}
+		record(err)
+		s.cancel(err)
+	}
+}
+
+func (s *Service[T]) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errs = append(s.errs, err)
+}
+
+// validationError stands in for the kind of typed error
+// a real producer might hand to the consumer.
+type validationError struct {
+	field string
+}
+
+func (e *validationError) Error() string {
+	return fmt.Sprintf("invalid value for %s", e.field)
+}
+
+func producer(ctx context.Context, ch chan<- error) error {
 	for i := 0; i < 10; i++ {
 		if i%2 == 0 {
 			fmt.Println("publishing")
-			ch <- fmt.Errorf("error on %d", i)
+
+			err := fmt.Errorf("error on %d: %w", i, context.DeadlineExceeded)
+			if i%4 == 0 {
+				err = fmt.Errorf("error on %d: %w", i, &validationError{field: "foo"})
+			}
+
+			select {
+			case ch <- err:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 	}
 
-	close(ch)
+	return nil
 }
 
-func consumer(ch <-chan error, closed chan<- int) {
-	var cnt int
-	for range ch {
+func main() {
+	classifier := NewClassifier(DefaultSamples)
+	classifier.RegisterSentinel("timeout", context.DeadlineExceeded)
+	RegisterType[*validationError](classifier, "validation error")
+
+	groups := classifier.Groups()
+	consumer := func(ctx context.Context, err error) error {
 		fmt.Println("consuming")
-		cnt += 1
+		return groups.Consume(ctx, err)
 	}
 
-	closed <- cnt
-	close(closed)
-}
-
-func main() {
-	s := NewService()
+	s := NewService(context.Background(), producer, consumer, WithConsumers(3), WithOrderedResults())
 
 	s.StartProducer()
 	s.StartConsumer()
 
-	s.Done()
-
+	res := s.Done()
+	fmt.Printf("handled %s\n", groups.Summary())
+	if res.Cause != nil {
+		fmt.Printf("stopped early: %s\n", res.Cause)
+	}
 }
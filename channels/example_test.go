@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// produceN publishes the numbers [0, n) unless cancelled.
+func produceN(n int) ProducerFunc[int] {
+	return func(ctx context.Context, out chan<- int) error {
+		for i := 0; i < n; i++ {
+			select {
+			case out <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+func TestServiceCompletes(t *testing.T) {
+	assert := assert.New(t)
+
+	errOdd := errors.New("odd")
+	s := NewService(context.Background(), produceN(10), func(_ context.Context, i int) error {
+		if i%2 == 1 {
+			return errOdd
+		}
+		return nil
+	})
+	s.StartProducer()
+	s.StartConsumer()
+
+	res := s.Done()
+
+	assert.Equal(10, res.Count)
+	assert.Len(res.Errs, 5)
+	assert.ErrorIs(res.Errs[0], errOdd)
+	assert.NoError(res.Cause)
+}
+
+func TestServiceStop(t *testing.T) {
+	assert := assert.New(t)
+
+	// never runs out of work, so the only way out is Stop
+	s := NewService(context.Background(), produceN(math.MaxInt), func(context.Context, int) error {
+		return nil
+	})
+	s.StartProducer()
+	s.StartConsumer()
+
+	s.Stop()
+	res := s.Done()
+
+	assert.ErrorIs(res.Cause, ErrStopped)
+	assert.Empty(res.Errs)
+}
+
+func TestServiceStoppedAfterFinishing(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewService(context.Background(), produceN(3), func(context.Context, int) error {
+		return nil
+	})
+	s.StartProducer()
+	s.StartConsumer()
+
+	// everything's done, but nobody has asked for the result yet
+	s.wg.Wait()
+	s.Stop()
+	res := s.Done()
+
+	assert.Equal(3, res.Count)
+	assert.NoError(res.Cause, "stopped too late to cut anything short")
+}
+
+func TestServiceStopWithoutConsumers(t *testing.T) {
+	s := NewService(context.Background(), produceN(math.MaxInt), func(context.Context, int) error {
+		return nil
+	})
+	s.StartProducer()
+
+	// the first item has nowhere to go, Stop must still free the producer
+	s.Stop()
+
+	done := make(chan Result, 1)
+	go func() { done <- s.Done() }()
+
+	select {
+	case res := <-done:
+		assert.ErrorIs(t, res.Cause, ErrStopped)
+	case <-time.After(time.Second):
+		t.Fatal("Stop didn't release the producer")
+	}
+}
+
+func TestServiceParentCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewService(ctx, produceN(math.MaxInt), func(context.Context, int) error {
+		return nil
+	})
+	s.StartProducer()
+	s.StartConsumer()
+
+	cancel()
+	res := s.Done()
+
+	assert.ErrorIs(res.Cause, context.Canceled)
+}
+
+func TestServiceConsumerPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	errBoom := errors.New("boom")
+	s := NewService(context.Background(), produceN(math.MaxInt), func(_ context.Context, i int) error {
+		if i == 3 {
+			panic(errBoom)
+		}
+		return nil
+	})
+	s.StartProducer()
+	s.StartConsumer()
+
+	res := s.Done()
+
+	var panicErr *PanicError
+	assert.ErrorAs(res.Cause, &panicErr)
+	assert.ErrorIs(res.Cause, errBoom)
+	assert.NotEmpty(panicErr.Stack)
+	assert.Equal(3, res.Count)
+	assert.Len(res.Errs, 1)
+}
+
+func TestServiceProducerPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewService(context.Background(), func(ctx context.Context, out chan<- int) error {
+		out <- 1
+		panic("oh no")
+	}, func(context.Context, int) error {
+		return nil
+	})
+	s.StartProducer()
+	s.StartConsumer()
+
+	res := s.Done()
+
+	var panicErr *PanicError
+	assert.ErrorAs(res.Cause, &panicErr)
+	assert.Equal("oh no", panicErr.Value)
+	// the consumer may or may not get to the item before it sees the cancellation
+	assert.LessOrEqual(res.Count, 1)
+}
+
+func TestServiceProducerError(t *testing.T) {
+	assert := assert.New(t)
+
+	errProduce := errors.New("upstream went away")
+	s := NewService(context.Background(), func(ctx context.Context, out chan<- int) error {
+		return errProduce
+	}, func(context.Context, int) error {
+		return nil
+	})
+	s.StartProducer()
+	s.StartConsumer()
+
+	res := s.Done()
+
+	assert.ErrorIs(res.Cause, errProduce)
+	assert.Equal([]error{errProduce}, res.Errs)
+}
+
+func TestServiceMultipleConsumers(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewService(context.Background(), produceN(100), func(_ context.Context, i int) error {
+		return fmt.Errorf("error on %d", i)
+	}, WithConsumers(4))
+	s.StartProducer()
+	s.StartConsumer()
+
+	res := s.Done()
+
+	assert.Equal(100, res.Count)
+	assert.Len(res.Errs, 100)
+	assert.Len(res.Consumers, 4)
+
+	// each consumer's errors show up as a contiguous block, in start order
+	var total int
+	var grouped []error
+	for _, c := range res.Consumers {
+		total += c.Count
+		grouped = append(grouped, c.Errs...)
+	}
+	assert.Equal(100, total)
+	assert.Equal(grouped, res.Errs)
+}
+
+func TestServiceOrderedResults(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewService(context.Background(), produceN(100), func(_ context.Context, i int) error {
+		// shuffle the finishing order a little
+		if i%3 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return fmt.Errorf("error on %d", i)
+	}, WithConsumers(4), WithOrderedResults())
+	s.StartProducer()
+	s.StartConsumer()
+
+	res := s.Done()
+
+	assert.Len(res.Errs, 100)
+	for i, err := range res.Errs {
+		assert.EqualError(err, fmt.Sprintf("error on %d", i))
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The stages below are the producer/consumer shape from Service, pulled apart
+// into pieces that can be chained together. They all follow the same rules:
+//   - a stage owns the channel it returns, and closes it when its input is
+//     closed or ctx is cancelled, whichever comes first.
+//   - every send also watches ctx, so a cancelled pipeline never leaves a
+//     goroutine blocked on a reader that went away.
+//   - after cancellation, stages stop reading their inputs. Upstream stages
+//     built on the same ctx wind down on their own; anything else feeding a
+//     stage should be wrapped in OrDone or watch ctx itself.
+
+// send delivers v on out unless ctx is cancelled first, and reports
+// whether the value was delivered.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// OrDone forwards everything from in until in is closed or ctx is cancelled.
+// It's useful for ranging over a channel the caller doesn't control without
+// having to write the select by hand.
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok || !send(ctx, out, v) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Map applies fn to every item from in.
+func Map[T, U any](ctx context.Context, in <-chan T, fn func(T) U) <-chan U {
+	out := make(chan U)
+
+	go func() {
+		defer close(out)
+
+		for v := range OrDone(ctx, in) {
+			if !send(ctx, out, fn(v)) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Filter forwards only the items from in that keep returns true for.
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		for v := range OrDone(ctx, in) {
+			if !keep(v) {
+				continue
+			}
+			if !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// FanOut spreads the items from in across n channels. Each item goes to
+// exactly one of them, whichever reader is ready first, so a slow reader
+// doesn't hold up the others. Every output must be drained or ctx cancelled.
+// An n below 1 is treated as 1, so in is always read.
+func FanOut[T any](ctx context.Context, in <-chan T, n int) []<-chan T {
+	if n < 1 {
+		n = 1
+	}
+
+	outs := make([]<-chan T, n)
+
+	for i := range outs {
+		out := make(chan T)
+		outs[i] = out
+
+		// the workers compete for items on the shared input,
+		// which is what balances the load between them.
+		go func() {
+			defer close(out)
+
+			for v := range OrDone(ctx, in) {
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+		}()
+	}
+
+	return outs
+}
+
+// FanIn merges several channels into one. The output is closed once every
+// input is closed, or ctx is cancelled. Ordering between inputs isn't preserved.
+func FanIn[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		in := in
+		go func() {
+			defer wg.Done()
+
+			for v := range OrDone(ctx, in) {
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Batch groups items from in into slices of up to size items. If maxWait is
+// greater than zero, a partial batch is sent once its first item has waited
+// that long. Whatever is left when in closes is sent as a final short batch,
+// but a partial batch is dropped if ctx is cancelled.
+func Batch[T any](ctx context.Context, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	out := make(chan []T)
+
+	go func() {
+		defer close(out)
+
+		var batch []T
+		// a nil channel blocks forever, so there's no deadline
+		// until the first item of a batch arrives.
+		var deadline <-chan time.Time
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		flush := func() bool {
+			if timer != nil {
+				timer.Stop()
+				timer, deadline = nil, nil
+			}
+			b := batch
+			batch = nil
+			return send(ctx, out, b)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-deadline:
+				timer, deadline = nil, nil
+				if !flush() {
+					return
+				}
+			case v, ok := <-in:
+				if !ok {
+					if len(batch) > 0 {
+						flush()
+					}
+					return
+				}
+
+				batch = append(batch, v)
+				if len(batch) == 1 && maxWait > 0 {
+					timer = time.NewTimer(maxWait)
+					deadline = timer.C
+				}
+				if len(batch) >= size && !flush() {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Tee copies every item from in to both returned channels. An item isn't
+// read from in until both copies have been delivered, so both outputs
+// must be drained, otherwise the slower reader holds up the faster one.
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	out1 := make(chan T)
+	out2 := make(chan T)
+
+	go func() {
+		defer close(out1)
+		defer close(out2)
+
+		for v := range OrDone(ctx, in) {
+			// shadow the outputs so each can be switched off
+			// once it has received its copy.
+			out1, out2 := out1, out2
+			for i := 0; i < 2; i++ {
+				select {
+				case <-ctx.Done():
+					return
+				case out1 <- v:
+					out1 = nil
+				case out2 <- v:
+					out2 = nil
+				}
+			}
+		}
+	}()
+
+	return out1, out2
+}
+
+// Throttle forwards items from in no faster than one per interval.
+// An interval that isn't positive doesn't throttle at all.
+func Throttle[T any](ctx context.Context, in <-chan T, interval time.Duration) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		if interval <= 0 {
+			for v := range OrDone(ctx, in) {
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for v := range OrDone(ctx, in) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
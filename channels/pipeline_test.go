@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkLeaks fails the test if it started goroutines that
+// are still running once it's finished.
+func checkLeaks(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	t.Cleanup(func() {
+		// stages shut down asynchronously, give them a moment
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before, "leaked goroutines")
+	})
+}
+
+// generate publishes the numbers [0, n) and closes the channel.
+func generate(ctx context.Context, n int) <-chan int {
+	out := make(chan int)
+
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			if !send(ctx, out, i) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func collect[T any](in <-chan T) []T {
+	var got []T
+	for v := range in {
+		got = append(got, v)
+	}
+	return got
+}
+
+func TestMapFilter(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	evens := Filter(ctx, generate(ctx, 10), func(i int) bool { return i%2 == 0 })
+	doubled := Map(ctx, evens, func(i int) int { return i * 2 })
+
+	assert.Equal(t, []int{0, 4, 8, 12, 16}, collect(doubled))
+}
+
+func TestFanOutFanIn(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	outs := FanOut(ctx, generate(ctx, 100), 4)
+	assert.Len(t, outs, 4)
+
+	got := collect(FanIn(ctx, outs...))
+	sort.Ints(got)
+
+	want := make([]int, 100)
+	for i := range want {
+		want[i] = i
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestFanOutAtLeastOne(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	for _, n := range []int{0, -1} {
+		outs := FanOut(ctx, generate(ctx, 3), n)
+		if assert.Len(t, outs, 1, n) {
+			assert.Equal(t, []int{0, 1, 2}, collect(outs[0]), n)
+		}
+	}
+}
+
+func TestBatchBySize(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	got := collect(Batch(ctx, generate(ctx, 7), 3, 0))
+
+	assert.Equal(t, [][]int{{0, 1, 2}, {3, 4, 5}, {6}}, got)
+}
+
+func TestBatchByTime(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	in := make(chan int)
+	batches := Batch(ctx, in, 10, 10*time.Millisecond)
+
+	in <- 1
+	in <- 2
+	// the batch isn't full, so it should turn up once maxWait passes
+	assert.Equal(t, []int{1, 2}, <-batches)
+
+	in <- 3
+	close(in)
+	assert.Equal(t, []int{3}, <-batches)
+
+	_, ok := <-batches
+	assert.False(t, ok)
+}
+
+func TestTee(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	out1, out2 := Tee(ctx, generate(ctx, 5))
+
+	// drain both at once, Tee needs both readers to make progress
+	got2 := make(chan []int)
+	go func() { got2 <- collect(out2) }()
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, collect(out1))
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, <-got2)
+}
+
+func TestThrottle(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	interval := 5 * time.Millisecond
+	start := time.Now()
+	got := collect(Throttle(ctx, generate(ctx, 4), interval))
+
+	assert.Equal(t, []int{0, 1, 2, 3}, got)
+	assert.GreaterOrEqual(t, time.Since(start), 4*interval)
+}
+
+func TestThrottleWithoutInterval(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+
+	assert.Equal(t, []int{0, 1, 2, 3}, collect(Throttle(ctx, generate(ctx, 4), 0)))
+	assert.Equal(t, []int{0, 1, 2, 3}, collect(Throttle(ctx, generate(ctx, 4), -time.Second)))
+}
+
+func TestOrDone(t *testing.T) {
+	checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// nothing ever closes this channel, so only ctx can stop OrDone
+	in := make(chan int)
+	out := OrDone(ctx, in)
+
+	cancel()
+	_, ok := <-out
+	assert.False(t, ok)
+}
+
+func TestCancelledPipelineDoesNotLeak(t *testing.T) {
+	checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// build a pipeline from every stage, then walk away after reading
+	// a single item. All the goroutines should still exit.
+	src := generate(ctx, 1000)
+	a, b := Tee(ctx, src)
+	go collect(b)
+	mapped := Map(ctx, a, func(i int) int { return i + 1 })
+	filtered := Filter(ctx, mapped, func(int) bool { return true })
+	merged := FanIn(ctx, FanOut(ctx, filtered, 3)...)
+	batched := Batch(ctx, merged, 2, time.Millisecond)
+	throttled := Throttle(ctx, batched, time.Millisecond)
+
+	<-throttled
+	cancel()
+
+	// the output must close on its own once ctx is cancelled
+	for range throttled {
+	}
+}
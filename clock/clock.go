@@ -0,0 +1,111 @@
+// Package clock lets code that waits on time be tested without waiting.
+//
+// Code takes a Clock instead of calling the time package directly, and
+// is given New() in production and a Fake in tests, which only moves
+// when the test tells it to.
+package clock
+
+import "time"
+
+// Clock is the part of the time package that deals with the current
+// time and waiting.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+
+	// After waits for d to pass, then sends the current time on the channel.
+	After(d time.Duration) <-chan time.Time
+
+	// Sleep pauses the current goroutine for at least d.
+	Sleep(d time.Duration)
+
+	// NewTimer creates a Timer that fires once, after d.
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker creates a Ticker that fires every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer, with the channel behind a method
+// so it can be faked.
+type Timer interface {
+	// C returns the channel the time is sent on.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing, see time.Timer.Stop.
+	Stop() bool
+
+	// Reset changes the timer to fire after d, see time.Timer.Reset.
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker, with the channel behind a method
+// so it can be faked.
+type Ticker interface {
+	// C returns the channel the ticks are sent on.
+	C() <-chan time.Time
+
+	// Stop turns off the Ticker, see time.Ticker.Stop.
+	Stop()
+
+	// Reset changes the ticker's period to d, see time.Ticker.Reset.
+	Reset(d time.Duration)
+}
+
+// New returns a Clock backed by the time package.
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+// Now implements Clock for realClock.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Since implements Clock for realClock.
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// After implements Clock for realClock.
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Sleep implements Clock for realClock.
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// NewTimer implements Clock for realClock.
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// NewTicker implements Clock for realClock.
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+// C implements Timer for realTimer.
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+// C implements Ticker for realTicker.
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
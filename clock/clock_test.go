@@ -0,0 +1,142 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fired reports whether ch has something to receive.
+func fired(ch <-chan time.Time) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestRealClock(t *testing.T) {
+	assert := assert.New(t)
+
+	c := clock.New()
+	before := c.Now()
+
+	timer := c.NewTimer(time.Millisecond)
+	<-timer.C()
+	assert.False(timer.Stop())
+
+	ticker := c.NewTicker(time.Millisecond)
+	<-ticker.C()
+	ticker.Stop()
+
+	<-c.After(time.Millisecond)
+	c.Sleep(time.Millisecond)
+
+	assert.GreaterOrEqual(c.Since(before), 4*time.Millisecond)
+}
+
+func TestFakeNow(t *testing.T) {
+	assert := assert.New(t)
+
+	c := clock.NewFake(start)
+	assert.Equal(start, c.Now())
+
+	c.Advance(time.Hour)
+	assert.Equal(start.Add(time.Hour), c.Now())
+	assert.Equal(time.Hour, c.Since(start))
+}
+
+func TestFakeTimer(t *testing.T) {
+	assert := assert.New(t)
+
+	c := clock.NewFake(start)
+	timer := c.NewTimer(time.Second)
+	assert.Equal(1, c.Waiters())
+
+	c.Advance(999 * time.Millisecond)
+	assert.False(fired(timer.C()))
+
+	c.Advance(time.Millisecond)
+	select {
+	case now := <-timer.C():
+		assert.Equal(start.Add(time.Second), now)
+	default:
+		assert.Fail("timer didn't fire")
+	}
+	assert.Equal(0, c.Waiters())
+	assert.False(timer.Stop())
+
+	assert.False(timer.Reset(time.Second))
+	assert.True(timer.Stop())
+	c.Advance(time.Hour)
+	assert.False(fired(timer.C()))
+}
+
+func TestFakeTimerFiresImmediately(t *testing.T) {
+	c := clock.NewFake(start)
+
+	assert.True(t, fired(c.After(0)))
+	assert.True(t, fired(c.After(-time.Second)))
+	assert.Equal(t, 0, c.Waiters())
+}
+
+func TestFakeTicker(t *testing.T) {
+	assert := assert.New(t)
+
+	c := clock.NewFake(start)
+	ticker := c.NewTicker(time.Second)
+
+	var ticks []time.Time
+	for i := 0; i < 3; i++ {
+		c.Advance(time.Second)
+		ticks = append(ticks, <-ticker.C())
+	}
+	assert.Equal([]time.Time{start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second)}, ticks)
+
+	// ticks nobody receives are dropped
+	c.Advance(10 * time.Second)
+	assert.Equal(start.Add(4*time.Second), <-ticker.C())
+	assert.False(fired(ticker.C()))
+
+	ticker.Reset(time.Minute)
+	c.Advance(time.Second)
+	assert.False(fired(ticker.C()))
+	c.Advance(time.Minute)
+	assert.True(fired(ticker.C()))
+
+	ticker.Stop()
+	assert.Equal(0, c.Waiters())
+	c.Advance(time.Hour)
+	assert.False(fired(ticker.C()))
+}
+
+func TestFakeFiresInOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	c := clock.NewFake(start)
+	late := c.After(2 * time.Second)
+	early := c.After(time.Second)
+
+	c.Advance(time.Hour)
+	assert.Equal(start.Add(time.Second), <-early)
+	assert.Equal(start.Add(2*time.Second), <-late)
+}
+
+func TestFakeSleep(t *testing.T) {
+	c := clock.NewFake(start)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Sleep(time.Minute)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	<-done
+}
@@ -0,0 +1,223 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when Advance is called. Anything
+// waiting on it, through After, Sleep, a Timer or a Ticker, fires as
+// Advance moves past its time, in time order.
+//
+// Tests usually start the code under test in a goroutine, call
+// BlockUntil to know it's waiting, then Advance.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+// NewFake creates a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+
+	return f
+}
+
+// Now implements Clock for Fake.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Since implements Clock for Fake.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After implements Clock for Fake.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Sleep implements Clock for Fake. It returns once
+// another goroutine has advanced the clock by d.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// NewTimer implements Clock for Fake.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{clock: f, ch: make(chan time.Time, 1)}
+	f.scheduleLocked(t, d)
+
+	return t
+}
+
+// NewTicker implements Clock for Fake.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{clock: f, ch: make(chan time.Time, 1), period: d}
+	f.scheduleLocked(t, d)
+
+	return fakeTicker{t}
+}
+
+// Advance moves the clock on by d, firing everything that comes due on
+// the way. Tickers fire once for each period, but like real tickers they
+// drop ticks that nobody has received.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	end := f.now.Add(d)
+	for {
+		next := f.nextLocked()
+		if next == nil || next.at.After(end) {
+			break
+		}
+
+		f.now = next.at
+		next.fire(f.now)
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			f.removeLocked(next)
+		}
+	}
+	f.now = end
+}
+
+// BlockUntil waits until at least n timers, tickers, or calls to
+// After or Sleep are waiting on the clock.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Waiters returns how many timers, tickers, or calls
+// to After or Sleep are waiting on the clock.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
+
+// scheduleLocked sets t to fire after d, firing it
+// straight away if d isn't positive.
+func (f *Fake) scheduleLocked(t *fakeTimer, d time.Duration) {
+	t.at = f.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		t.fire(f.now)
+		return
+	}
+
+	f.waiters = append(f.waiters, t)
+	f.cond.Broadcast()
+}
+
+// nextLocked returns the waiter due to fire first. Ties go to the one
+// that has been waiting longest, so timers set up in order fire in order.
+func (f *Fake) nextLocked() *fakeTimer {
+	var next *fakeTimer
+	for _, t := range f.waiters {
+		if next == nil || t.at.Before(next.at) {
+			next = t
+		}
+	}
+
+	return next
+}
+
+// removeLocked stops t waiting, and reports whether it was.
+func (f *Fake) removeLocked(t *fakeTimer) bool {
+	for i, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// fakeTimer is the Timer for Fake, and is behind
+// its Ticker too. Tickers have a period.
+type fakeTimer struct {
+	clock  *Fake
+	ch     chan time.Time
+	at     time.Time
+	period time.Duration
+}
+
+// fire sends now, unless the last time hasn't been received.
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+// C implements Timer for fakeTimer.
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop implements Timer for fakeTimer.
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	return t.clock.removeLocked(t)
+}
+
+// Reset implements Timer for fakeTimer.
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.clock.removeLocked(t)
+	if t.period > 0 {
+		t.period = d
+	}
+	t.clock.scheduleLocked(t, d)
+
+	return active
+}
+
+// fakeTicker adapts fakeTimer to Ticker, whose methods don't return anything.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+// Stop implements Ticker for fakeTicker.
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
+
+// Reset implements Ticker for fakeTicker.
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+
+	t.fakeTimer.Reset(d)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// ErrorCode is a stable, machine readable identifier for a kind
+// of error. Unlike messages, codes are part of the API contract:
+// clients branch on them, so once published they must never change.
+type ErrorCode string
+
+// CodedError knows which application error code it represents.
+type CodedError interface {
+	// CodedErrors must also be errors.
+	error
+
+	// ErrorCode returns the application error code for the error.
+	ErrorCode() ErrorCode
+}
+
+var (
+	// ErrDuplicateCode is returned when registering a code that's already taken.
+	ErrDuplicateCode = errors.New("error code already registered")
+
+	// ErrInvalidCode is returned when registering a code that isn't UPPER_SNAKE_CASE.
+	ErrInvalidCode = errors.New("error code must be UPPER_SNAKE_CASE")
+
+	codePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]*(_[A-Z0-9]+)*$`)
+)
+
+// CodeInfo describes a registered error code.
+type CodeInfo struct {
+	// Code is the registered error code.
+	Code ErrorCode
+
+	// Description is a short, developer facing explanation of the code.
+	Description string
+}
+
+// Registry keeps track of error codes, and guarantees
+// that no two kinds of error share a code.
+type Registry struct {
+	mu    sync.RWMutex
+	codes map[ErrorCode]CodeInfo
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{codes: make(map[ErrorCode]CodeInfo)}
+}
+
+// Register adds code to the registry.
+func (r *Registry) Register(code ErrorCode, description string) error {
+	if !codePattern.MatchString(string(code)) {
+		return fmt.Errorf("%w: %q", ErrInvalidCode, code)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.codes[code]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateCode, code)
+	}
+
+	r.codes[code] = CodeInfo{Code: code, Description: description}
+	return nil
+}
+
+// Lookup returns the details of a registered code.
+func (r *Registry) Lookup(code ErrorCode) (CodeInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	info, ok := r.codes[code]
+	return info, ok
+}
+
+// Codes lists every registered code, sorted by code.
+func (r *Registry) Codes() []CodeInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	infos := make([]CodeInfo, 0, len(r.codes))
+	for _, info := range r.codes {
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Code < infos[j].Code
+	})
+
+	return infos
+}
+
+// DefaultRegistry holds the codes for every error in the application.
+var DefaultRegistry = NewRegistry()
+
+// MustRegister adds code to DefaultRegistry. It panics if the code
+// is invalid or taken, which makes clashes show up at startup
+// rather than in a client's error handling.
+func MustRegister(code ErrorCode, description string) ErrorCode {
+	if err := DefaultRegistry.Register(code, description); err != nil {
+		panic(err)
+	}
+
+	return code
+}
+
+// Codes for the errors in this package.
+var (
+	// CodeUnknown is used for any error that doesn't carry its own code.
+	CodeUnknown = MustRegister("UNKNOWN", "an unexpected error occurred")
+
+	// CodeValidationFailed is used by ValidationError.
+	CodeValidationFailed = MustRegister("VALIDATION_FAILED", "the request contained invalid inputs")
+
+	// CodeUniqueViolation is used by UniqueConstraintViolatedError.
+	CodeUniqueViolation = MustRegister("UNIQUE_VIOLATION", "a record with the same unique key already exists")
+)
+
+// codeOf returns the application error code carried by err,
+// falling back to CodeUnknown.
+func codeOf(err error) ErrorCode {
+	var coded CodedError
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+
+	return CodeUnknown
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewRegistry()
+	assert.NoError(r.Register("SOME_CODE", "first"))
+	assert.ErrorIs(r.Register("SOME_CODE", "second"), ErrDuplicateCode)
+
+	info, ok := r.Lookup("SOME_CODE")
+	assert.True(ok)
+	assert.Equal("first", info.Description)
+}
+
+func TestRegistryRejectsInvalidCodes(t *testing.T) {
+	r := NewRegistry()
+
+	for _, code := range []ErrorCode{"", "lower", "TRAILING_", "DOUBLE__UNDERSCORE", "1LEADING_DIGIT"} {
+		assert.ErrorIs(t, r.Register(code, ""), ErrInvalidCode, code)
+	}
+}
+
+func TestMustRegisterPanicsOnDuplicate(t *testing.T) {
+	assert.Panics(t, func() {
+		MustRegister(CodeValidationFailed, "again")
+	})
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	var codes []ErrorCode
+	for _, info := range DefaultRegistry.Codes() {
+		codes = append(codes, info.Code)
+	}
+
+	assert.Subset(t, codes, []ErrorCode{CodeUnknown, CodeUniqueViolation, CodeValidationFailed})
+	assert.IsIncreasing(t, codes)
+}
+
+func TestHandleErrorEmitsCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{&ValidationError{}, CodeValidationFailed},
+		{&UniqueConstraintViolatedError{}, CodeUniqueViolation},
+		{assert.AnError, CodeUnknown},
+	}
+
+	quiet := WithErrorLog(log.New(io.Discard, "", 0))
+	for _, tt := range tests {
+		// asking for plain JSON still gets Problem Details
+		for _, accept := range []string{"", "application/json"} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			HandleError(w, r, tt.err, quiet)
+
+			assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"), accept)
+			var problem map[string]any
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+			assert.Equal(t, string(tt.code), problem["code"], accept)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		HandleError(w, r, tt.err, quiet, WithLegacyFormat())
+
+		var resp ErrorResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, tt.code, resp.ErrorCode)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Codes for database constraint violations.
+var (
+	// CodeForeignKeyViolation is used by ForeignKeyViolatedError.
+	CodeForeignKeyViolation = MustRegister("FOREIGN_KEY_VIOLATION", "the record refers to another record that doesn't exist, or is still referred to")
+
+	// CodeNotNullViolation is used by NotNullViolatedError.
+	CodeNotNullViolation = MustRegister("NOT_NULL_VIOLATION", "a required value was missing")
+
+	// CodeCheckViolation is used by CheckViolatedError.
+	CodeCheckViolation = MustRegister("CHECK_VIOLATION", "a value was outside the range the database allows")
+)
+
+// ConstraintViolation holds what the database told us about a violated
+// constraint. It's embedded in each of the constraint error types.
+type ConstraintViolation struct {
+	// Constraint is the name of the violated constraint, if the driver reported it.
+	Constraint string
+
+	// Column is the column involved, if the driver reported it.
+	Column string
+
+	// Err is the original driver error.
+	Err error
+}
+
+// Unwrap returns the original driver error, so it still shows up in logs.
+func (c ConstraintViolation) Unwrap() error {
+	return c.Err
+}
+
+// describe formats the constraint details for Error.
+func (c ConstraintViolation) describe() string {
+	var parts []string
+	if c.Constraint != "" {
+		parts = append(parts, "constraint "+c.Constraint)
+	}
+	if c.Column != "" {
+		parts = append(parts, "column "+c.Column)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// ForeignKeyViolatedError indicates that a database save failed because it
+// referred to a missing record, or a delete left references dangling.
+type ForeignKeyViolatedError struct {
+	ConstraintViolation
+}
+
+// Status implements StatusAwareError for ForeignKeyViolatedError
+func (e *ForeignKeyViolatedError) Status() int {
+	// 409 because the request conflicts with the state of the system
+	return http.StatusConflict
+}
+
+// ErrorCode implements CodedError for ForeignKeyViolatedError
+func (e *ForeignKeyViolatedError) ErrorCode() ErrorCode {
+	return CodeForeignKeyViolation
+}
+
+// GRPCCode implements GRPCStatusAwareError for ForeignKeyViolatedError.
+// 409 would map to AlreadyExists, but nothing exists that shouldn't,
+// the system just isn't in the state the request needs.
+func (e *ForeignKeyViolatedError) GRPCCode() GRPCCode {
+	return GRPCFailedPrecondition
+}
+
+// Error implements error interface for ForeignKeyViolatedError.
+func (e *ForeignKeyViolatedError) Error() string {
+	return e.PublicMessage() + e.describe()
+}
+
+// PublicMessage implements PublicError for ForeignKeyViolatedError.
+func (e *ForeignKeyViolatedError) PublicMessage() string {
+	return "cannot save record because it refers to a record that doesn't exist, or is still referred to"
+}
+
+// NotNullViolatedError indicates that a database save
+// failed because a required column had no value.
+type NotNullViolatedError struct {
+	ConstraintViolation
+}
+
+// Status implements StatusAwareError for NotNullViolatedError
+func (e *NotNullViolatedError) Status() int {
+	// 400 because the request was missing something
+	return http.StatusBadRequest
+}
+
+// ErrorCode implements CodedError for NotNullViolatedError
+func (e *NotNullViolatedError) ErrorCode() ErrorCode {
+	return CodeNotNullViolation
+}
+
+// Error implements error interface for NotNullViolatedError.
+func (e *NotNullViolatedError) Error() string {
+	return e.PublicMessage() + e.describe()
+}
+
+// PublicMessage implements PublicError for NotNullViolatedError.
+func (e *NotNullViolatedError) PublicMessage() string {
+	return "cannot save record because a required value is missing"
+}
+
+// CheckViolatedError indicates that a database save
+// failed because a check constraint rejected a value.
+type CheckViolatedError struct {
+	ConstraintViolation
+}
+
+// Status implements StatusAwareError for CheckViolatedError
+func (e *CheckViolatedError) Status() int {
+	// 400 because the request contained a bad value
+	return http.StatusBadRequest
+}
+
+// ErrorCode implements CodedError for CheckViolatedError
+func (e *CheckViolatedError) ErrorCode() ErrorCode {
+	return CodeCheckViolation
+}
+
+// Error implements error interface for CheckViolatedError.
+func (e *CheckViolatedError) Error() string {
+	return e.PublicMessage() + e.describe()
+}
+
+// PublicMessage implements PublicError for CheckViolatedError.
+func (e *CheckViolatedError) PublicMessage() string {
+	return "cannot save record because a value is not allowed"
+}
+
+// ***********************
+// translate driver errors
+// ***********************
+
+// DBErrorTranslator recognises errors from a particular kind of database
+// driver, and converts constraint violations into our error types.
+// It returns false for errors it doesn't recognise.
+type DBErrorTranslator func(err error) (error, bool)
+
+// DBErrorTranslators are tried in order by TranslateDBError.
+var DBErrorTranslators = []DBErrorTranslator{
+	TranslatePostgresError,
+	TranslateSQLiteError,
+}
+
+// TranslateDBError converts constraint violations reported by the database
+// driver into status aware errors, so the repository layer can return
+// them without knowing how they'll be reported. Anything else is
+// returned unchanged.
+func TranslateDBError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, translate := range DBErrorTranslators {
+		if translated, ok := translate(err); ok {
+			return translated
+		}
+	}
+
+	return err
+}
+
+// SQLSTATE codes for integrity constraint violations (class 23).
+//
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	sqlStateNotNull    = "23502"
+	sqlStateForeignKey = "23503"
+	sqlStateUnique     = "23505"
+	sqlStateCheck      = "23514"
+)
+
+// sqlStater is implemented by Postgres driver errors, including
+// pgconn.PgError (pgx) and pq.Error (lib/pq).
+type sqlStater interface {
+	error
+	SQLState() string
+}
+
+// TranslatePostgresError translates errors from drivers that report a SQLSTATE.
+func TranslatePostgresError(err error) (error, bool) {
+	var pgErr sqlStater
+	if !errors.As(err, &pgErr) {
+		return nil, false
+	}
+
+	violation := ConstraintViolation{
+		// pgx and lib/pq name these fields differently
+		Constraint: stringField(pgErr, "ConstraintName", "Constraint"),
+		Column:     stringField(pgErr, "ColumnName", "Column"),
+		Err:        err,
+	}
+
+	return newConstraintError(pgErr.SQLState(), violation)
+}
+
+// SQLite extended result codes for constraint violations.
+//
+// See https://www.sqlite.org/rescode.html
+const (
+	sqliteConstraint           = 19
+	sqliteConstraintCheck      = 275
+	sqliteConstraintForeignKey = 787
+	sqliteConstraintNotNull    = 1299
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// sqliteMessage matches SQLite's constraint messages, e.g.
+// "UNIQUE constraint failed: users.email". Foreign key failures
+// don't say which constraint failed.
+var sqliteMessage = regexp.MustCompile(`(UNIQUE|NOT NULL|CHECK|FOREIGN KEY) constraint failed(?:: ([^\s()]+))?`)
+
+// TranslateSQLiteError translates errors from SQLite drivers, which report
+// an extended result code: a Code() int method for modernc.org/sqlite,
+// or an ExtendedCode field for mattn/go-sqlite3.
+func TranslateSQLiteError(err error) (error, bool) {
+	code, ok := sqliteCode(err)
+	if !ok {
+		return nil, false
+	}
+
+	// the primary result code is the low byte of the extended one
+	if code&0xff != sqliteConstraint {
+		return nil, false
+	}
+
+	violation := ConstraintViolation{Err: err}
+
+	// SQLite only puts the details in the message
+	kind := ""
+	if m := sqliteMessage.FindStringSubmatch(err.Error()); m != nil {
+		kind = m[1]
+		if kind == "CHECK" {
+			violation.Constraint = m[2]
+		} else {
+			violation.Column = m[2]
+		}
+	}
+
+	switch {
+	case code == sqliteConstraintUnique, code == sqliteConstraintPrimaryKey, kind == "UNIQUE":
+		return newConstraintError(sqlStateUnique, violation)
+	case code == sqliteConstraintForeignKey, kind == "FOREIGN KEY":
+		return newConstraintError(sqlStateForeignKey, violation)
+	case code == sqliteConstraintNotNull, kind == "NOT NULL":
+		return newConstraintError(sqlStateNotNull, violation)
+	case code == sqliteConstraintCheck, kind == "CHECK":
+		return newConstraintError(sqlStateCheck, violation)
+	default:
+		return nil, false
+	}
+}
+
+// newConstraintError creates the error type for a SQLSTATE.
+func newConstraintError(state string, violation ConstraintViolation) (error, bool) {
+	switch state {
+	case sqlStateUnique:
+		return &UniqueConstraintViolatedError{violation}, true
+	case sqlStateForeignKey:
+		return &ForeignKeyViolatedError{violation}, true
+	case sqlStateNotNull:
+		return &NotNullViolatedError{violation}, true
+	case sqlStateCheck:
+		return &CheckViolatedError{violation}, true
+	default:
+		return nil, false
+	}
+}
+
+// sqliteCode finds the extended result code on a SQLite driver error.
+func sqliteCode(err error) (int64, bool) {
+	var coded interface {
+		error
+		Code() int
+	}
+	if errors.As(err, &coded) {
+		return int64(coded.Code()), true
+	}
+
+	// mattn/go-sqlite3 uses fields rather than methods, and
+	// returns its Error by value, so look through the chain by hand.
+	for ; err != nil; err = errors.Unwrap(err) {
+		if code, ok := intField(err, "ExtendedCode"); ok {
+			return code, true
+		}
+	}
+
+	return 0, false
+}
+
+// NOTE: the helpers below read driver error fields by name, so this package
+// doesn't need to import every driver. If you only use one driver, it's
+// simpler to errors.As to its error type directly.
+
+// stringField returns the first non-empty string field
+// of v with one of the given names.
+func stringField(v any, names ...string) string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return ""
+	}
+
+	for _, name := range names {
+		f := rv.FieldByName(name)
+		if f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+			return f.String()
+		}
+	}
+
+	return ""
+}
+
+// intField returns the integer field of v with the given name.
+func intField(v any, name string) (int64, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	f := rv.FieldByName(name)
+	if !f.IsValid() || !f.CanInt() {
+		return 0, false
+	}
+
+	return f.Int(), true
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pgError has the same shape as pgconn.PgError.
+type pgError struct {
+	Code           string
+	Message        string
+	ConstraintName string
+	ColumnName     string
+}
+
+func (e *pgError) Error() string    { return "ERROR: " + e.Message + " (SQLSTATE " + e.Code + ")" }
+func (e *pgError) SQLState() string { return e.Code }
+
+// pqError has the same shape as pq.Error, which names fields differently.
+type pqError struct {
+	Code       string
+	Message    string
+	Constraint string
+	Column     string
+}
+
+func (e *pqError) Error() string    { return "pq: " + e.Message }
+func (e *pqError) SQLState() string { return e.Code }
+
+// mattnError has the same shape as sqlite3.Error from mattn/go-sqlite3,
+// which is returned by value.
+type mattnError struct {
+	Code         int
+	ExtendedCode int
+	err          string
+}
+
+func (e mattnError) Error() string { return e.err }
+
+// moderncError has the same shape as sqlite.Error from modernc.org/sqlite.
+type moderncError struct {
+	code int
+	msg  string
+}
+
+func (e *moderncError) Error() string { return e.msg }
+func (e *moderncError) Code() int     { return e.code }
+
+// fakeDriver is a database/sql driver whose statements always
+// fail with whatever error the query text names.
+type fakeDriver struct {
+	errs map[string]error
+}
+
+type fakeConn struct{ d *fakeDriver }
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+// ExecContext implements driver.ExecerContext, so database/sql skips Prepare.
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	return nil, c.d.errs[query]
+}
+
+var fakeErrs = map[string]error{
+	"pgx unique": &pgError{Code: "23505", Message: `duplicate key value violates unique constraint "users_email_key"`, ConstraintName: "users_email_key"},
+	"pgx fk":     &pgError{Code: "23503", Message: "insert or update violates foreign key constraint", ConstraintName: "orders_user_id_fkey"},
+	"pq notnull": &pqError{Code: "23502", Message: `null value in column "name" violates not-null constraint`, Column: "name"},
+	"pq check":   &pqError{Code: "23514", Message: "new row violates check constraint", Constraint: "age_positive"},
+	"pq syntax":  &pqError{Code: "42601", Message: "syntax error"},
+
+	"mattn unique":  mattnError{Code: 19, ExtendedCode: 2067, err: "UNIQUE constraint failed: users.email"},
+	"mattn pk":      mattnError{Code: 19, ExtendedCode: 1555, err: "UNIQUE constraint failed: users.id"},
+	"mattn notnull": mattnError{Code: 19, ExtendedCode: 1299, err: "NOT NULL constraint failed: users.name"},
+	"mattn busy":    mattnError{Code: 5, ExtendedCode: 5, err: "database is locked"},
+
+	"modernc fk":    &moderncError{code: 787, msg: "constraint failed: FOREIGN KEY constraint failed (787)"},
+	"modernc check": &moderncError{code: 275, msg: "constraint failed: CHECK constraint failed: age_positive (275)"},
+	// a primary code only, so the message decides
+	"modernc plain": &moderncError{code: 19, msg: "constraint failed: NOT NULL constraint failed: users.name (19)"},
+}
+
+func init() {
+	sql.Register("customerr-fake", &fakeDriver{errs: fakeErrs})
+}
+
+func TestTranslateDBError(t *testing.T) {
+	db, err := sql.Open("customerr-fake", "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	tests := []struct {
+		query      string
+		want       error
+		constraint string
+		column     string
+	}{
+		{"pgx unique", &UniqueConstraintViolatedError{}, "users_email_key", ""},
+		{"pgx fk", &ForeignKeyViolatedError{}, "orders_user_id_fkey", ""},
+		{"pq notnull", &NotNullViolatedError{}, "", "name"},
+		{"pq check", &CheckViolatedError{}, "age_positive", ""},
+		{"mattn unique", &UniqueConstraintViolatedError{}, "", "users.email"},
+		{"mattn pk", &UniqueConstraintViolatedError{}, "", "users.id"},
+		{"mattn notnull", &NotNullViolatedError{}, "", "users.name"},
+		{"modernc fk", &ForeignKeyViolatedError{}, "", ""},
+		{"modernc check", &CheckViolatedError{}, "age_positive", ""},
+		{"modernc plain", &NotNullViolatedError{}, "", "users.name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert := assert.New(t)
+
+			_, execErr := db.Exec(tt.query)
+			err := TranslateDBError(fmt.Errorf("saving: %w", execErr))
+
+			assert.IsType(tt.want, err)
+
+			// every translated error exposes the same details
+			var violation interface {
+				StatusAwareError
+				Unwrap() error
+			}
+			assert.ErrorAs(err, &violation)
+			assert.ErrorIs(err, execErr)
+
+			var details ConstraintViolation
+			switch e := err.(type) {
+			case *UniqueConstraintViolatedError:
+				details = e.ConstraintViolation
+			case *ForeignKeyViolatedError:
+				details = e.ConstraintViolation
+			case *NotNullViolatedError:
+				details = e.ConstraintViolation
+			case *CheckViolatedError:
+				details = e.ConstraintViolation
+			}
+			assert.Equal(tt.constraint, details.Constraint)
+			assert.Equal(tt.column, details.Column)
+		})
+	}
+}
+
+func TestTranslateDBErrorPassesThrough(t *testing.T) {
+	for _, err := range []error{nil, fakeErrs["pq syntax"], fakeErrs["mattn busy"], sql.ErrNoRows} {
+		assert.Equal(t, err, TranslateDBError(err))
+	}
+}
+
+func TestConstraintErrorsHideSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	err := TranslateDBError(fakeErrs["pgx unique"])
+
+	assert.Equal("cannot save record because another exists with the same ID (constraint users_email_key)", err.Error())
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, err)
+
+	var problem Problem
+	assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal(http.StatusConflict, problem.Status)
+	assert.Equal("cannot save record because another exists with the same ID", problem.Detail)
+	assert.NotContains(w.Body.String(), "users_email_key")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Encoder renders error responses in a particular format.
+type Encoder interface {
+	// ContentType is sent as the response's Content-Type header.
+	ContentType() string
+
+	// Encode writes d to w.
+	Encode(w io.Writer, d ErrorDetails) error
+}
+
+// ProblemJSONEncoder writes application/problem+json documents.
+type ProblemJSONEncoder struct{}
+
+// ContentType implements Encoder for ProblemJSONEncoder.
+func (ProblemJSONEncoder) ContentType() string {
+	return ProblemContentType
+}
+
+// Encode implements Encoder for ProblemJSONEncoder.
+func (ProblemJSONEncoder) Encode(w io.Writer, d ErrorDetails) error {
+	return json.NewEncoder(w).Encode(NewProblem(d))
+}
+
+// JSONEncoder writes the ErrorResponse format that predates Problem Details.
+type JSONEncoder struct{}
+
+// ContentType implements Encoder for JSONEncoder.
+func (JSONEncoder) ContentType() string {
+	return "application/json"
+}
+
+// Encode implements Encoder for JSONEncoder.
+func (JSONEncoder) Encode(w io.Writer, d ErrorDetails) error {
+	// NOTE: or whatever - this is just an example of how to
+	// somewhat generically handle errors that you want to
+	// bubble up to users.
+	resp := ErrorResponse{
+		Code:          d.Status,
+		ErrorCode:     d.Code,
+		Message:       d.Message,
+		CorrelationID: d.CorrelationID,
+		Fields:        d.Fields,
+	}
+
+	return json.NewEncoder(w).Encode(&resp)
+}
+
+// TextEncoder writes a short, human readable description of the error.
+type TextEncoder struct{}
+
+// ContentType implements Encoder for TextEncoder.
+func (TextEncoder) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Encode implements Encoder for TextEncoder.
+func (TextEncoder) Encode(w io.Writer, d ErrorDetails) error {
+	if _, err := fmt.Fprintf(w, "%s: %s\n", d.Code, d.Message); err != nil {
+		return err
+	}
+
+	for _, f := range d.Fields {
+		if _, err := fmt.Fprintf(w, "  - %s\n", f.publicMessage()); err != nil {
+			return err
+		}
+	}
+
+	if d.CorrelationID != "" {
+		if _, err := fmt.Fprintf(w, "correlation id: %s\n", d.CorrelationID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GRPCStatusContentType is the media type for google.rpc.Status
+// documents, as written by GRPCStatusJSONEncoder.
+const GRPCStatusContentType = "application/grpc-status+json"
+
+// GRPCStatusJSONEncoder writes the JSON form of a google.rpc.Status,
+// the way gRPC gateways report errors, for clients that already know
+// how to handle those. The error code is in a google.rpc.ErrorInfo
+// detail, and failed fields in a google.rpc.BadRequest one.
+//
+// See https://cloud.google.com/apis/design/errors for the details.
+type GRPCStatusJSONEncoder struct{}
+
+// grpcStatus is the JSON mapping of google.rpc.Status.
+type grpcStatus struct {
+	Code    GRPCCode `json:"code"`
+	Message string   `json:"message"`
+	Details []any    `json:"details"`
+}
+
+// grpcErrorInfo is the JSON mapping of google.rpc.ErrorInfo.
+type grpcErrorInfo struct {
+	Type     string            `json:"@type"`
+	Reason   ErrorCode         `json:"reason"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// grpcBadRequest is the JSON mapping of google.rpc.BadRequest.
+type grpcBadRequest struct {
+	Type            string               `json:"@type"`
+	FieldViolations []grpcFieldViolation `json:"fieldViolations"`
+}
+
+type grpcFieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// ContentType implements Encoder for GRPCStatusJSONEncoder.
+func (GRPCStatusJSONEncoder) ContentType() string {
+	return GRPCStatusContentType
+}
+
+// Encode implements Encoder for GRPCStatusJSONEncoder.
+func (GRPCStatusJSONEncoder) Encode(w io.Writer, d ErrorDetails) error {
+	info := grpcErrorInfo{
+		Type:   "type.googleapis.com/google.rpc.ErrorInfo",
+		Reason: d.Code,
+	}
+	if d.CorrelationID != "" {
+		info.Metadata = map[string]string{"correlationId": d.CorrelationID}
+	}
+
+	s := grpcStatus{
+		Code:    d.GRPCCode,
+		Message: d.Message,
+		Details: []any{info},
+	}
+
+	if len(d.Fields) > 0 {
+		br := grpcBadRequest{Type: "type.googleapis.com/google.rpc.BadRequest"}
+		for _, f := range d.Fields {
+			br.FieldViolations = append(br.FieldViolations, grpcFieldViolation{Field: f.Field, Description: f.Message})
+		}
+		s.Details = append(s.Details, br)
+	}
+
+	return json.NewEncoder(w).Encode(s)
+}
+
+// XMLEncoder writes application/problem+xml documents, the
+// XML flavour of Problem Details.
+type XMLEncoder struct{}
+
+// xmlProblem follows the XML schema in RFC 9457 Appendix B,
+// where arrays are represented by repeated <i> elements.
+type xmlProblem struct {
+	XMLName       xml.Name   `xml:"urn:ietf:rfc:7807 problem"`
+	Type          string     `xml:"type,omitempty"`
+	Title         string     `xml:"title,omitempty"`
+	Status        int        `xml:"status,omitempty"`
+	Detail        string     `xml:"detail,omitempty"`
+	Instance      string     `xml:"instance,omitempty"`
+	Code          ErrorCode  `xml:"code"`
+	CorrelationID string     `xml:"correlationId,omitempty"`
+	Errors        []xmlField `xml:"errors>i,omitempty"`
+}
+
+type xmlField struct {
+	Field   string `xml:"field"`
+	Rule    string `xml:"rule"`
+	Value   string `xml:"value,omitempty"`
+	Message string `xml:"message"`
+}
+
+// ContentType implements Encoder for XMLEncoder.
+func (XMLEncoder) ContentType() string {
+	return "application/problem+xml"
+}
+
+// Encode implements Encoder for XMLEncoder.
+func (XMLEncoder) Encode(w io.Writer, d ErrorDetails) error {
+	p := xmlProblem{
+		Type:          d.Type,
+		Title:         d.Title,
+		Status:        d.Status,
+		Detail:        d.Message,
+		Instance:      d.Instance,
+		Code:          d.Code,
+		CorrelationID: d.CorrelationID,
+	}
+
+	for _, f := range d.Fields {
+		field := xmlField{Field: f.Field, Rule: f.Rule, Message: f.Message}
+		if f.Value != nil {
+			field.Value = fmt.Sprint(f.Value)
+		}
+		p.Errors = append(p.Errors, field)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	return xml.NewEncoder(w).Encode(p)
+}
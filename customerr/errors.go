@@ -21,10 +21,10 @@ Related reading:
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -46,18 +46,33 @@ type ErrorResponse struct {
 	// HTTP Status code of the error.
 	Code int
 
+	// Application error code, stable across releases
+	// so clients can branch on it.
+	ErrorCode ErrorCode
+
 	// Message indicating what went wrong.
 	Message string
+
+	// CorrelationID ties a server error to its log entry.
+	CorrelationID string `json:",omitempty"`
+
+	// Fields lists the individual problems with the request's inputs.
+	Fields []FieldError `json:",omitempty"`
 }
 
 // ValidationError indicates user provided
 // inputs are invalid.
-type ValidationError struct{}
+type ValidationError struct {
+	// Fields lists each input that failed validation, and why.
+	Fields []FieldError
+}
 
 // UniqueConstraintViolatedError indicates that
 // a database save failed because a uniqe constraint
 // on the table was violated.
-type UniqueConstraintViolatedError struct{}
+type UniqueConstraintViolatedError struct {
+	ConstraintViolation
+}
 
 // ******************************
 // implement our StatusAwareError
@@ -75,6 +90,20 @@ func (e *UniqueConstraintViolatedError) Status() int {
 	return http.StatusConflict
 }
 
+// ************************************
+// implement CodedError on custom types
+// ************************************
+
+// ErrorCode implements CodedError for ValidationError
+func (e *ValidationError) ErrorCode() ErrorCode {
+	return CodeValidationFailed
+}
+
+// ErrorCode implements CodedError for UniqueConstraintViolatedError
+func (e *UniqueConstraintViolatedError) ErrorCode() ErrorCode {
+	return CodeUniqueViolation
+}
+
 // *****************************************
 // implement error interface on custom types
 // *****************************************
@@ -85,7 +114,27 @@ func (e *UniqueConstraintViolatedError) Status() int {
 // See https://pkg.go.dev/builtin#error
 // for the definition of this interface.
 func (e *ValidationError) Error() string {
-	return "the provided inputs are invalid for the following reasons: [foo, bar, baz]"
+	return e.describe(FieldError.Error)
+}
+
+// PublicMessage implements PublicError for ValidationError, leaving out
+// the errors that were merged into it, which might be internal.
+func (e *ValidationError) PublicMessage() string {
+	return e.describe(FieldError.publicMessage)
+}
+
+// describe lists the failed fields, each described by reason.
+func (e *ValidationError) describe(reason func(FieldError) string) string {
+	if len(e.Fields) == 0 {
+		return "the provided inputs are invalid"
+	}
+
+	reasons := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		reasons = append(reasons, reason(f))
+	}
+
+	return "the provided inputs are invalid for the following reasons: [" + strings.Join(reasons, ", ") + "]"
 }
 
 // Error implements error interface
@@ -94,6 +143,12 @@ func (e *ValidationError) Error() string {
 // See https://pkg.go.dev/builtin#error
 // for the definition of this interface.
 func (e *UniqueConstraintViolatedError) Error() string {
+	return e.PublicMessage() + e.describe()
+}
+
+// PublicMessage implements PublicError for UniqueConstraintViolatedError,
+// leaving out the constraint details, which describe our schema.
+func (e *UniqueConstraintViolatedError) PublicMessage() string {
 	return "cannot save record because another exists with the same ID"
 }
 
@@ -101,9 +156,68 @@ func (e *UniqueConstraintViolatedError) Error() string {
 // implement our error handler function
 // ************************************
 
-// HandleError handles any error raised by the application and
-// creates an appropriate HTTP response.
-func HandleError(w http.ResponseWriter, err error) {
+// HandlerOption changes how HandleError writes its response.
+type HandlerOption func(*handlerConfig)
+
+type handlerConfig struct {
+	legacy    bool
+	typeBase  string
+	instance  string
+	errorLog  *log.Logger
+	encoders  []mediaEncoder
+	catalogs  *Catalogs
+	route     string
+	observers []Observer
+}
+
+func newHandlerConfig(opts []HandlerOption) handlerConfig {
+	cfg := handlerConfig{
+		errorLog: log.Default(),
+		encoders: defaultEncoders(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// WithLegacyFormat writes the original ErrorResponse JSON instead of
+// Problem Details when the client doesn't ask for anything specific,
+// or just asks for application/json, for clients that haven't migrated yet.
+func WithLegacyFormat() HandlerOption {
+	return func(c *handlerConfig) {
+		c.legacy = true
+	}
+}
+
+// WithProblemTypeBase sets the URI that problem types are built from,
+// e.g. "https://example.com/problems" gives types like
+// "https://example.com/problems/validation-failed".
+func WithProblemTypeBase(uri string) HandlerOption {
+	return func(c *handlerConfig) {
+		c.typeBase = uri
+	}
+}
+
+// WithInstance sets the URI identifying this occurrence of the problem.
+// By default it's the path of the request that failed.
+func WithInstance(uri string) HandlerOption {
+	return func(c *handlerConfig) {
+		c.instance = uri
+	}
+}
+
+// WithErrorLog sets where server errors are logged. By default
+// they go to the standard logger.
+func WithErrorLog(l *log.Logger) HandlerOption {
+	return func(c *handlerConfig) {
+		c.errorLog = l
+	}
+}
+
+// statusOf returns the HTTP status code for err.
+func statusOf(err error) int {
 	// 500 by default, as that represents an unknown state
 	responseCode := http.StatusInternalServerError
 
@@ -111,33 +225,253 @@ func HandleError(w http.ResponseWriter, err error) {
 	// it's status indication
 	var statusAware StatusAwareError
 	if errors.As(err, &statusAware) {
-		responseCode = statusAware.Status()
+		return statusAware.Status()
+	}
+
+	// errors written for gRPC first can still be served over HTTP
+	var grpcAware GRPCStatusAwareError
+	if errors.As(err, &grpcAware) {
+		responseCode = HTTPStatusFromGRPC(grpcAware.GRPCCode())
+	}
+
+	return responseCode
+}
+
+// ErrorDetails is everything that goes into an error response,
+// before it's rendered in any particular format.
+type ErrorDetails struct {
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// Code is the application error code.
+	Code ErrorCode
+
+	// GRPCCode is the gRPC status code for the error, for
+	// formats that follow gRPC's conventions.
+	GRPCCode GRPCCode
+
+	// Type is a URI identifying the kind of problem, if one is configured.
+	Type string
+
+	// Title is a short summary of the kind of problem.
+	Title string
+
+	// Message is safe to show to the caller.
+	Message string
+
+	// Language is the locale Message is in, if it was localized.
+	Language string
+
+	// Instance identifies this particular occurrence of the problem.
+	Instance string
+
+	// CorrelationID ties a server error to its log entry.
+	CorrelationID string
+
+	// Fields lists the inputs that failed validation, if any.
+	Fields []FieldError
+
+	// Extensions are any extra members the error asked to include.
+	Extensions map[string]any
+}
+
+// describe works out what to tell the caller about err.
+func describe(r *http.Request, err error, cfg handlerConfig) ErrorDetails {
+	responseCode := statusOf(err)
+	message, specific := publicMessage(err, responseCode)
+
+	d := ErrorDetails{
+		Status:   responseCode,
+		Code:     codeOf(err),
+		GRPCCode: grpcCodeOf(err),
+		Title:    http.StatusText(responseCode),
+		Message:  message,
+		Instance: cfg.instance,
+	}
+
+	if d.Instance == "" && r != nil {
+		d.Instance = r.URL.Path
+	}
+
+	if cfg.typeBase != "" {
+		d.Type = strings.TrimSuffix(cfg.typeBase, "/") + "/" + strings.ToLower(strings.ReplaceAll(string(d.Code), "_", "-"))
+		// with a specific type, the title should describe
+		// that type rather than the status code.
+		if info, ok := DefaultRegistry.Lookup(d.Code); ok {
+			d.Title = info.Description
+		}
+	}
+
+	var validation *ValidationError
+	if errors.As(err, &validation) {
+		d.Fields = validation.Fields
+	}
+
+	var extender ProblemExtender
+	if errors.As(err, &extender) {
+		d.Extensions = extender.ProblemExtensions()
+	}
+
+	if cfg.catalogs != nil {
+		localize(r, err, &d, specific, cfg.catalogs)
+	}
+
+	return d
+}
+
+// localize replaces d's message, title and field messages with ones in
+// the caller's language. A message the error chose for itself is only
+// replaced if its code has a catalog entry; CodeUnknown covers all kinds
+// of errors, so its entry only stands in for GenericMessage.
+func localize(r *http.Request, err error, d *ErrorDetails, specific bool, catalogs *Catalogs) {
+	var chain []string
+	if r != nil {
+		chain = LocaleChain(r.Header.Get("Accept-Language"))
+	}
+
+	localizeMessage(chain, err, d, specific, catalogs)
+
+	// describe titles typed problems after their code
+	titleKey := StatusTitleKey(d.Status)
+	if d.Type != "" {
+		titleKey = TitleKey(d.Code)
+	}
+	if title, _, ok := catalogs.Message(chain, titleKey, nil); ok {
+		d.Title = title
+	}
+
+	if len(d.Fields) == 0 {
+		return
+	}
+
+	// a copy, the error's own fields stay as they are
+	fields := make([]FieldError, len(d.Fields))
+	for i, f := range d.Fields {
+		if f.params != nil {
+			if msg, _, ok := catalogs.Message(chain, RuleKey(f.Rule), f.params); ok {
+				f.Message = msg
+			}
+		}
+		fields[i] = f
+	}
+	d.Fields = fields
+
+	// Problem Details lists the fields as an extension
+	if _, ok := d.Extensions["errors"]; ok {
+		d.Extensions["errors"] = fields
+	}
+}
+
+// localizeMessage replaces d's message, see localize.
+func localizeMessage(chain []string, err error, d *ErrorDetails, specific bool, catalogs *Catalogs) {
+	code := d.Code
+	if !specific {
+		code = CodeUnknown
+	} else if code == CodeUnknown {
+		return
 	}
 
-	// NOTE: or whatever - this is just an example of how to
-	// somewhat generically handle errors that you want to
-	// bubble up to users.
-	resp := ErrorResponse{
-		Code: responseCode,
-		// NOTE: probably _not_ a good idea, this can leak a lot of info
-		Message: err.Error(),
+	var params map[string]any
+	var paramsErr MessageParamsError
+	if errors.As(err, &paramsErr) {
+		params = paramsErr.MessageParams()
 	}
 
-	w.WriteHeader(responseCode)
-	json.NewEncoder(w).Encode(&resp)
+	if msg, locale, ok := catalogs.Message(chain, code, params); ok {
+		d.Message = msg
+		d.Language = locale
+	}
+}
+
+// HandleError handles any error raised by the application and
+// creates an appropriate HTTP response. The format of the response
+// is negotiated with the request's Accept header, falling back to
+// application/problem+json. r may be nil if there's no request at hand.
+//
+// Server errors are never described to the caller. Instead they get
+// a generic message and a correlation ID, and the full error is logged
+// against that ID.
+func HandleError(w http.ResponseWriter, r *http.Request, err error, opts ...HandlerOption) {
+	cfg := newHandlerConfig(opts)
+
+	details := describe(r, err, cfg)
+	if details.Status >= http.StatusInternalServerError {
+		details.CorrelationID = newCorrelationID()
+		logError(cfg.errorLog, "server error", details.CorrelationID, err)
+	} else if wrapped(err) {
+		// client errors aren't usually worth logging, but someone
+		// wrapped this one to record where it came from
+		logError(cfg.errorLog, "client error", "", err)
+	}
+
+	cfg.observe(ErrorEvent{
+		Status:        details.Status,
+		Code:          details.Code,
+		Route:         routeOf(r, cfg),
+		CorrelationID: details.CorrelationID,
+		Err:           err,
+	})
+
+	var accept string
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+	enc := cfg.negotiate(accept)
+
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.Header().Add("Vary", "Accept")
+	if cfg.catalogs != nil {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	if details.Language != "" {
+		w.Header().Set("Content-Language", details.Language)
+	}
+	w.WriteHeader(details.Status)
+	if err := enc.Encode(w, details); err != nil {
+		// too late to change the response, all we can do is make a note
+		cfg.errorLog.Printf("encoding error response: %s", err)
+	}
 }
 
 func main() {
 	// validation error example:
+	validation := &ValidationError{}
+	validation.Required("foo", "")
+	validation.MinLength("bar", "ab", 3)
+	validation.OneOf("baz", "qux", "red", "green", "blue")
+
 	w := httptest.NewRecorder()
-	HandleError(w, &ValidationError{})
+	HandleError(w, nil, validation.Err())
 	buf := strings.Builder{}
 	io.Copy(&buf, w.Result().Body)
 	fmt.Println(buf.String())
 
 	// unique constraint violation example:
 	w = httptest.NewRecorder()
-	HandleError(w, &UniqueConstraintViolatedError{})
+	HandleError(w, nil, &UniqueConstraintViolatedError{})
+	buf = strings.Builder{}
+	io.Copy(&buf, w.Result().Body)
+	fmt.Println(buf.String())
+
+	// unexpected error example, the details only show up in the log:
+	w = httptest.NewRecorder()
+	HandleError(w, nil, fmt.Errorf("saving widget: %w", errors.New("dial tcp 10.0.0.7:5432: connection refused")))
+	buf = strings.Builder{}
+	io.Copy(&buf, w.Result().Body)
+	fmt.Println(buf.String())
+
+	// plain text example:
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/widgets", nil)
+	r.Header.Set("Accept", "text/plain")
+	HandleError(w, r, validation)
+	buf = strings.Builder{}
+	io.Copy(&buf, w.Result().Body)
+	fmt.Println(buf.String())
+
+	// legacy format example:
+	w = httptest.NewRecorder()
+	HandleError(w, nil, validation, WithLegacyFormat())
 	buf = strings.Builder{}
 	io.Copy(&buf, w.Result().Body)
 	fmt.Println(buf.String())
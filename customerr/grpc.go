@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// GRPCCode is a gRPC status code. The values match
+// google.golang.org/grpc/codes, so converting is just codes.Code(c),
+// without this package having to depend on gRPC.
+type GRPCCode uint32
+
+// gRPC status codes.
+//
+// See https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	GRPCOK                 GRPCCode = 0
+	GRPCCanceled           GRPCCode = 1
+	GRPCUnknown            GRPCCode = 2
+	GRPCInvalidArgument    GRPCCode = 3
+	GRPCDeadlineExceeded   GRPCCode = 4
+	GRPCNotFound           GRPCCode = 5
+	GRPCAlreadyExists      GRPCCode = 6
+	GRPCPermissionDenied   GRPCCode = 7
+	GRPCResourceExhausted  GRPCCode = 8
+	GRPCFailedPrecondition GRPCCode = 9
+	GRPCAborted            GRPCCode = 10
+	GRPCOutOfRange         GRPCCode = 11
+	GRPCUnimplemented      GRPCCode = 12
+	GRPCInternal           GRPCCode = 13
+	GRPCUnavailable        GRPCCode = 14
+	GRPCDataLoss           GRPCCode = 15
+	GRPCUnauthenticated    GRPCCode = 16
+)
+
+var grpcCodeNames = map[GRPCCode]string{
+	GRPCOK:                 "OK",
+	GRPCCanceled:           "Canceled",
+	GRPCUnknown:            "Unknown",
+	GRPCInvalidArgument:    "InvalidArgument",
+	GRPCDeadlineExceeded:   "DeadlineExceeded",
+	GRPCNotFound:           "NotFound",
+	GRPCAlreadyExists:      "AlreadyExists",
+	GRPCPermissionDenied:   "PermissionDenied",
+	GRPCResourceExhausted:  "ResourceExhausted",
+	GRPCFailedPrecondition: "FailedPrecondition",
+	GRPCAborted:            "Aborted",
+	GRPCOutOfRange:         "OutOfRange",
+	GRPCUnimplemented:      "Unimplemented",
+	GRPCInternal:           "Internal",
+	GRPCUnavailable:        "Unavailable",
+	GRPCDataLoss:           "DataLoss",
+	GRPCUnauthenticated:    "Unauthenticated",
+}
+
+// String returns the name of the code, as used by the gRPC libraries.
+func (c GRPCCode) String() string {
+	if name, ok := grpcCodeNames[c]; ok {
+		return name
+	}
+
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// GRPCStatusAwareError knows what gRPC status code the error
+// should return to a caller. It's optional: errors that only
+// implement StatusAwareError get a code mapped from their HTTP status.
+type GRPCStatusAwareError interface {
+	// GRPCStatusAwareErrors must also be errors.
+	error
+
+	// GRPCCode returns the gRPC status code associated with the error.
+	GRPCCode() GRPCCode
+}
+
+// statusMapping pairs an HTTP status with a gRPC code.
+type statusMapping struct {
+	status int
+	code   GRPCCode
+}
+
+// bidirectionalMappings translate the same way in both directions.
+var bidirectionalMappings = []statusMapping{
+	{http.StatusOK, GRPCOK},
+	{http.StatusBadRequest, GRPCInvalidArgument},
+	{http.StatusUnauthorized, GRPCUnauthenticated},
+	{http.StatusForbidden, GRPCPermissionDenied},
+	{http.StatusNotFound, GRPCNotFound},
+	{http.StatusConflict, GRPCAlreadyExists},
+	{http.StatusTooManyRequests, GRPCResourceExhausted},
+	// not in net/http, but widely used for "client closed request"
+	{499, GRPCCanceled},
+	{http.StatusInternalServerError, GRPCInternal},
+	{http.StatusNotImplemented, GRPCUnimplemented},
+	{http.StatusServiceUnavailable, GRPCUnavailable},
+	{http.StatusGatewayTimeout, GRPCDeadlineExceeded},
+}
+
+// grpcOnlyMappings cover gRPC codes that are finer grained than HTTP,
+// several codes collapse into one status, so they only go one way.
+var grpcOnlyMappings = []statusMapping{
+	{http.StatusInternalServerError, GRPCUnknown},
+	{http.StatusBadRequest, GRPCFailedPrecondition},
+	{http.StatusConflict, GRPCAborted},
+	{http.StatusBadRequest, GRPCOutOfRange},
+	{http.StatusInternalServerError, GRPCDataLoss},
+}
+
+var (
+	httpToGRPC = make(map[int]GRPCCode)
+	grpcToHTTP = make(map[GRPCCode]int)
+)
+
+func init() {
+	for _, m := range bidirectionalMappings {
+		httpToGRPC[m.status] = m.code
+		grpcToHTTP[m.code] = m.status
+	}
+
+	for _, m := range grpcOnlyMappings {
+		grpcToHTTP[m.code] = m.status
+	}
+}
+
+// GRPCCodeFromHTTP returns the gRPC code for an HTTP status. Statuses
+// without a direct equivalent are mapped by class.
+func GRPCCodeFromHTTP(status int) GRPCCode {
+	if code, ok := httpToGRPC[status]; ok {
+		return code
+	}
+
+	switch {
+	case status >= 200 && status < 300:
+		return GRPCOK
+	case status >= 400 && status < 500:
+		// the request can't succeed as it is, but
+		// it's not clear the arguments are to blame
+		return GRPCFailedPrecondition
+	default:
+		return GRPCUnknown
+	}
+}
+
+// HTTPStatusFromGRPC returns the HTTP status for a gRPC code.
+func HTTPStatusFromGRPC(code GRPCCode) int {
+	if status, ok := grpcToHTTP[code]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// grpcCodeOf returns the gRPC code for err. An explicit GRPCCode wins,
+// otherwise it's mapped from the HTTP status, so errors only need to
+// implement StatusAwareError to work on both transports.
+//
+// The status aware error decides, like it does for statusOf, so an
+// error that was merged into a ValidationError doesn't get to pick.
+func grpcCodeOf(err error) GRPCCode {
+	var statusAware StatusAwareError
+	if errors.As(err, &statusAware) {
+		if grpcAware, ok := statusAware.(GRPCStatusAwareError); ok {
+			return grpcAware.GRPCCode()
+		}
+		return GRPCCodeFromHTTP(statusAware.Status())
+	}
+
+	var grpcAware GRPCStatusAwareError
+	if errors.As(err, &grpcAware) {
+		return grpcAware.GRPCCode()
+	}
+
+	return GRPCCodeFromHTTP(statusOf(err))
+}
+
+// GRPCStatus is what a gRPC server needs to report an error. With the
+// real gRPC libraries it becomes status.New(codes.Code(s.Code), s.Message).
+type GRPCStatus struct {
+	// Code is the gRPC status code.
+	Code GRPCCode
+
+	// Message is safe to show to the caller.
+	Message string
+
+	// ErrorCode is the application error code, for the status details.
+	ErrorCode ErrorCode
+
+	// CorrelationID identifies the log entry for a server error,
+	// it's empty for anything else.
+	CorrelationID string
+}
+
+// NewGRPCStatus is HandleError for gRPC: it works out the status to
+// return for err, with the same rules for what's safe to show. Server
+// errors are logged against a new correlation ID, so the caller has
+// something to quote. Options that don't apply to gRPC are ignored.
+func NewGRPCStatus(err error, opts ...HandlerOption) GRPCStatus {
+	cfg := newHandlerConfig(opts)
+
+	status := statusOf(err)
+	msg, _ := publicMessage(err, status)
+
+	s := GRPCStatus{
+		Code:      grpcCodeOf(err),
+		Message:   msg,
+		ErrorCode: codeOf(err),
+	}
+	if status >= http.StatusInternalServerError {
+		s.CorrelationID = newCorrelationID()
+		logError(cfg.errorLog, "server error", s.CorrelationID, err)
+	}
+
+	return s
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// notFoundError was written for gRPC, and doesn't know about HTTP.
+type notFoundError struct{}
+
+func (e *notFoundError) Error() string {
+	return "no such widget"
+}
+
+func (e *notFoundError) GRPCCode() GRPCCode {
+	return GRPCNotFound
+}
+
+func TestGRPCMappingsRoundTrip(t *testing.T) {
+	for _, m := range bidirectionalMappings {
+		assert.Equal(t, m.code, GRPCCodeFromHTTP(m.status), m.status)
+		assert.Equal(t, m.status, HTTPStatusFromGRPC(m.code), m.code.String())
+	}
+}
+
+func TestEveryGRPCCodeHasAStatus(t *testing.T) {
+	for code := range grpcCodeNames {
+		_, ok := grpcToHTTP[code]
+		assert.True(t, ok, code.String())
+	}
+}
+
+func TestGRPCCodeFromUnmappedHTTP(t *testing.T) {
+	assert.Equal(t, GRPCOK, GRPCCodeFromHTTP(http.StatusAccepted))
+	assert.Equal(t, GRPCFailedPrecondition, GRPCCodeFromHTTP(http.StatusUnprocessableEntity))
+	assert.Equal(t, GRPCUnknown, GRPCCodeFromHTTP(http.StatusBadGateway))
+}
+
+func TestNewGRPCStatus(t *testing.T) {
+	tests := []struct {
+		err       error
+		code      GRPCCode
+		errorCode ErrorCode
+	}{
+		{&ValidationError{}, GRPCInvalidArgument, CodeValidationFailed},
+		{fmt.Errorf("saving: %w", &UniqueConstraintViolatedError{}), GRPCAlreadyExists, CodeUniqueViolation},
+		{&notFoundError{}, GRPCNotFound, CodeUnknown},
+		{&ForeignKeyViolatedError{}, GRPCFailedPrecondition, CodeForeignKeyViolation},
+		{&NotNullViolatedError{}, GRPCInvalidArgument, CodeNotNullViolation},
+		{&CheckViolatedError{}, GRPCInvalidArgument, CodeCheckViolation},
+		{assert.AnError, GRPCInternal, CodeUnknown},
+	}
+
+	for _, tt := range tests {
+		s := NewGRPCStatus(tt.err, WithErrorLog(log.New(io.Discard, "", 0)))
+		assert.Equal(t, tt.code, s.Code, tt.err.Error())
+		assert.Equal(t, tt.errorCode, s.ErrorCode, tt.err.Error())
+	}
+
+	assert.Equal(t, GenericMessage, NewGRPCStatus(assert.AnError, WithErrorLog(log.New(io.Discard, "", 0))).Message)
+}
+
+func TestGRPCStatusLogsServerErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	errorLog := WithErrorLog(log.New(&logs, "", 0))
+
+	s := NewGRPCStatus(fmt.Errorf("loading widget: %w", errors.New("connection reset")), errorLog)
+	assert.Equal(GRPCInternal, s.Code)
+	assert.NotEmpty(s.CorrelationID)
+	assert.Contains(logs.String(), "correlation_id="+s.CorrelationID)
+	assert.Contains(logs.String(), "loading widget")
+	assert.NotContains(s.Message, "loading widget")
+
+	// client errors are the caller's to sort out
+	logs.Reset()
+	s = NewGRPCStatus(&notFoundError{}, errorLog)
+	assert.Empty(s.CorrelationID)
+	assert.Empty(logs.String())
+}
+
+func TestGRPCAwareErrorOverHTTP(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, statusOf(&notFoundError{}))
+}
+
+func TestGRPCCodeString(t *testing.T) {
+	assert.Equal(t, "AlreadyExists", GRPCAlreadyExists.String())
+	assert.Equal(t, "Code(42)", GRPCCode(42).String())
+}
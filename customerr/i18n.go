@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// MessageParamsError supplies the parameters used to fill in
+// its localized message template, e.g. {{.count}}.
+type MessageParamsError interface {
+	// MessageParamsErrors must also be errors.
+	error
+
+	// MessageParams returns the values the message templates can refer to.
+	MessageParams() map[string]any
+}
+
+// Catalogs holds localized messages for error codes, one catalog per
+// locale. Messages are text/template templates, filled in with the
+// error's MessageParams.
+type Catalogs struct {
+	defaultLocale string
+	catalogs      map[string]map[ErrorCode]*template.Template
+}
+
+// NewCatalogs creates an empty set of catalogs. defaultLocale ends
+// every fallback chain, so it should have a message for every code.
+func NewCatalogs(defaultLocale string) *Catalogs {
+	return &Catalogs{
+		defaultLocale: normalizeLocale(defaultLocale),
+		catalogs:      make(map[string]map[ErrorCode]*template.Template),
+	}
+}
+
+// Add adds messages for locale, merging them with any already added.
+func (c *Catalogs) Add(locale string, messages map[ErrorCode]string) error {
+	locale = normalizeLocale(locale)
+
+	catalog, ok := c.catalogs[locale]
+	if !ok {
+		catalog = make(map[ErrorCode]*template.Template, len(messages))
+		c.catalogs[locale] = catalog
+	}
+
+	for code, msg := range messages {
+		// missing parameters are an error rather than "<no value>",
+		// so a bad template falls back instead of reaching a caller.
+		tmpl, err := template.New(locale + "/" + string(code)).Option("missingkey=error").Parse(msg)
+		if err != nil {
+			return fmt.Errorf("parsing %s message for %s: %w", locale, code, err)
+		}
+		catalog[code] = tmpl
+	}
+
+	return nil
+}
+
+// Locales lists the locales with a catalog, sorted.
+func (c *Catalogs) Locales() []string {
+	locales := make([]string, 0, len(c.catalogs))
+	for locale := range c.catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+
+	return locales
+}
+
+// Has reports whether locale has a message for code, without falling back.
+func (c *Catalogs) Has(locale string, code ErrorCode) bool {
+	_, ok := c.catalogs[normalizeLocale(locale)][code]
+	return ok
+}
+
+// Message returns the message for code in the first locale of chain that
+// can produce one, along with that locale. The default locale is always
+// tried last.
+func (c *Catalogs) Message(chain []string, code ErrorCode, params map[string]any) (string, string, bool) {
+	locales := make([]string, 0, len(chain)+1)
+	locales = append(locales, chain...)
+	locales = append(locales, c.defaultLocale)
+
+	for _, locale := range locales {
+		tmpl, ok := c.catalogs[locale][code]
+		if !ok {
+			continue
+		}
+
+		var b strings.Builder
+		if err := tmpl.Execute(&b, params); err != nil {
+			continue
+		}
+
+		return b.String(), locale, true
+	}
+
+	return "", "", false
+}
+
+// normalizeLocale lower cases a language tag and uses "-" as the separator,
+// so "en_US" and "en-us" find the same catalog.
+func normalizeLocale(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// parentLocale drops the last subtag, e.g. "fr-ca" becomes "fr".
+func parentLocale(tag string) (string, bool) {
+	i := strings.LastIndex(tag, "-")
+	if i < 0 {
+		return "", false
+	}
+
+	return tag[:i], true
+}
+
+// LocaleChain turns an Accept-Language header into the list of locales
+// to try, most preferred first. Each language is followed by its more
+// general parents, so "fr-CA, en;q=0.5" gives [fr-ca fr en].
+func LocaleChain(acceptLanguage string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = normalizeLocale(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		tags = append(tags, weighted{tag, q})
+	}
+
+	// stable, so ties keep the order the client listed them in
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	var chain []string
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		for tag, ok := t.tag, true; ok; tag, ok = parentLocale(tag) {
+			if !seen[tag] {
+				seen[tag] = true
+				chain = append(chain, tag)
+			}
+		}
+	}
+
+	return chain
+}
+
+// Catalogs are keyed by ErrorCode, but also hold the titles of problems,
+// and the messages of the validation rules. Their keys can't clash with
+// codes, as codes have to be UPPER_SNAKE_CASE.
+
+// TitleKey returns the catalog key for the title of problems typed by code.
+func TitleKey(code ErrorCode) ErrorCode {
+	return ErrorCode("title:" + string(code))
+}
+
+// StatusTitleKey returns the catalog key for the title of untyped
+// problems with status.
+func StatusTitleKey(status int) ErrorCode {
+	return ErrorCode("title:" + strconv.Itoa(status))
+}
+
+// RuleKey returns the catalog key for the message of a validation rule.
+// Its template can refer to the rule's parameters: {{.min}} and {{.max}}
+// for lengths and ranges, and {{.allowed}} for RuleOneOf.
+func RuleKey(rule string) ErrorCode {
+	return ErrorCode("rule:" + rule)
+}
+
+// WithCatalogs localizes messages, titles and validation errors using the
+// request's Accept-Language header, falling back to the catalogs' default
+// locale. Anything that isn't in the catalogs is left as it is.
+func WithCatalogs(c *Catalogs) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.catalogs = c
+	}
+}
+
+// DefaultCatalogs holds the messages shipped with this package.
+var DefaultCatalogs = mustCatalogs("en", map[string]map[ErrorCode]string{
+	"en": {
+		CodeUnknown:             GenericMessage,
+		CodeValidationFailed:    "{{if eq .count 1}}1 input is{{else}}{{.count}} inputs are{{end}} invalid",
+		CodeUniqueViolation:     "cannot save record because another exists with the same ID",
+		CodeForeignKeyViolation: "cannot save record because it refers to a record that doesn't exist, or is still referred to",
+		CodeNotNullViolation:    "cannot save record because a required value is missing",
+		CodeCheckViolation:      "cannot save record because a value is not allowed",
+
+		TitleKey(CodeUnknown):             "an unexpected error occurred",
+		TitleKey(CodeValidationFailed):    "the request contained invalid inputs",
+		TitleKey(CodeUniqueViolation):     "a record with the same unique key already exists",
+		TitleKey(CodeForeignKeyViolation): "the record refers to another record that doesn't exist, or is still referred to",
+		TitleKey(CodeNotNullViolation):    "a required value was missing",
+		TitleKey(CodeCheckViolation):      "a value was outside the range the database allows",
+
+		StatusTitleKey(http.StatusBadRequest):          "Bad Request",
+		StatusTitleKey(http.StatusConflict):            "Conflict",
+		StatusTitleKey(http.StatusInternalServerError): "Internal Server Error",
+
+		RuleKey(RuleRequired):  "is required",
+		RuleKey(RuleMinLength): "must be at least {{.min}} characters",
+		RuleKey(RuleMaxLength): "must be at most {{.max}} characters",
+		RuleKey(RuleRange):     "must be between {{.min}} and {{.max}}",
+		RuleKey(RuleOneOf):     "must be one of [{{.allowed}}]",
+		RuleKey(RuleInvalid):   "is invalid",
+	},
+	"fr": {
+		CodeUnknown:             "une erreur inattendue s'est produite",
+		CodeValidationFailed:    "{{if eq .count 1}}1 valeur est invalide{{else}}{{.count}} valeurs sont invalides{{end}}",
+		CodeUniqueViolation:     "impossible d'enregistrer : un enregistrement avec le même identifiant existe déjà",
+		CodeForeignKeyViolation: "impossible d'enregistrer : l'enregistrement fait référence à un enregistrement inexistant, ou est encore référencé",
+		CodeNotNullViolation:    "impossible d'enregistrer : une valeur obligatoire est manquante",
+		CodeCheckViolation:      "impossible d'enregistrer : une valeur n'est pas autorisée",
+
+		TitleKey(CodeUnknown):             "une erreur inattendue s'est produite",
+		TitleKey(CodeValidationFailed):    "la requête contenait des valeurs invalides",
+		TitleKey(CodeUniqueViolation):     "un enregistrement avec la même clé unique existe déjà",
+		TitleKey(CodeForeignKeyViolation): "l'enregistrement fait référence à un enregistrement inexistant, ou est encore référencé",
+		TitleKey(CodeNotNullViolation):    "une valeur obligatoire était manquante",
+		TitleKey(CodeCheckViolation):      "une valeur était hors des limites autorisées par la base de données",
+
+		StatusTitleKey(http.StatusBadRequest):          "Requête incorrecte",
+		StatusTitleKey(http.StatusConflict):            "Conflit",
+		StatusTitleKey(http.StatusInternalServerError): "Erreur interne du serveur",
+
+		RuleKey(RuleRequired):  "est obligatoire",
+		RuleKey(RuleMinLength): "doit contenir au moins {{.min}} caractères",
+		RuleKey(RuleMaxLength): "doit contenir au plus {{.max}} caractères",
+		RuleKey(RuleRange):     "doit être compris entre {{.min}} et {{.max}}",
+		RuleKey(RuleOneOf):     "doit être l'une des valeurs [{{.allowed}}]",
+		RuleKey(RuleInvalid):   "est invalide",
+	},
+	"es": {
+		CodeUnknown:             "se produjo un error inesperado",
+		CodeValidationFailed:    "{{if eq .count 1}}1 valor no es válido{{else}}{{.count}} valores no son válidos{{end}}",
+		CodeUniqueViolation:     "no se puede guardar: ya existe un registro con el mismo identificador",
+		CodeForeignKeyViolation: "no se puede guardar: el registro hace referencia a un registro que no existe, o todavía está referenciado",
+		CodeNotNullViolation:    "no se puede guardar: falta un valor obligatorio",
+		CodeCheckViolation:      "no se puede guardar: un valor no está permitido",
+
+		TitleKey(CodeUnknown):             "se produjo un error inesperado",
+		TitleKey(CodeValidationFailed):    "la solicitud contenía valores no válidos",
+		TitleKey(CodeUniqueViolation):     "ya existe un registro con la misma clave única",
+		TitleKey(CodeForeignKeyViolation): "el registro hace referencia a un registro que no existe, o todavía está referenciado",
+		TitleKey(CodeNotNullViolation):    "faltaba un valor obligatorio",
+		TitleKey(CodeCheckViolation):      "un valor estaba fuera del rango que permite la base de datos",
+
+		StatusTitleKey(http.StatusBadRequest):          "Solicitud incorrecta",
+		StatusTitleKey(http.StatusConflict):            "Conflicto",
+		StatusTitleKey(http.StatusInternalServerError): "Error interno del servidor",
+
+		RuleKey(RuleRequired):  "es obligatorio",
+		RuleKey(RuleMinLength): "debe tener al menos {{.min}} caracteres",
+		RuleKey(RuleMaxLength): "debe tener como máximo {{.max}} caracteres",
+		RuleKey(RuleRange):     "debe estar entre {{.min}} y {{.max}}",
+		RuleKey(RuleOneOf):     "debe ser uno de [{{.allowed}}]",
+		RuleKey(RuleInvalid):   "no es válido",
+	},
+})
+
+// mustCatalogs builds Catalogs from literal messages, panicking
+// on bad templates so they're caught at startup.
+func mustCatalogs(defaultLocale string, messages map[string]map[ErrorCode]string) *Catalogs {
+	c := NewCatalogs(defaultLocale)
+	for locale, msgs := range messages {
+		if err := c.Add(locale, msgs); err != nil {
+			panic(err)
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// catalogKeys lists every key the shipped catalogs should have:
+// each code and its title, the titles of the statuses those codes
+// use, and every built in validation rule.
+func catalogKeys() []ErrorCode {
+	var keys []ErrorCode
+	for _, info := range DefaultRegistry.Codes() {
+		keys = append(keys, info.Code, TitleKey(info.Code))
+	}
+	for _, status := range []int{http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError} {
+		keys = append(keys, StatusTitleKey(status))
+	}
+	for _, rule := range []string{RuleRequired, RuleMinLength, RuleMaxLength, RuleRange, RuleOneOf, RuleInvalid} {
+		keys = append(keys, RuleKey(rule))
+	}
+
+	return keys
+}
+
+func TestEveryCodeHasEveryLocale(t *testing.T) {
+	locales := DefaultCatalogs.Locales()
+	assert.Contains(t, locales, "en")
+
+	for _, key := range catalogKeys() {
+		for _, locale := range locales {
+			assert.True(t, DefaultCatalogs.Has(locale, key), "%s has no %s message", key, locale)
+		}
+	}
+}
+
+func TestEveryMessageRenders(t *testing.T) {
+	// parameters every shipped template may refer to
+	params := map[string]any{"count": 2, "min": 1, "max": 8, "allowed": "CA, US"}
+
+	for _, key := range catalogKeys() {
+		for _, locale := range DefaultCatalogs.Locales() {
+			_, got, ok := DefaultCatalogs.Message([]string{locale}, key, params)
+			assert.True(t, ok, "%s/%s", locale, key)
+			assert.Equal(t, locale, got, "%s/%s fell back", locale, key)
+		}
+	}
+}
+
+func TestHandleErrorLocalizesTitlesAndFields(t *testing.T) {
+	assert := assert.New(t)
+
+	v := &ValidationError{}
+	v.Required("name", "")
+	v.Range("age", 200, 0, 150)
+	v.Check(false, "password", "strength", nil, "is too weak")
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Accept-Language", "fr")
+	w := httptest.NewRecorder()
+	HandleError(w, r, v, WithCatalogs(DefaultCatalogs))
+
+	var problem struct {
+		Title  string       `json:"title"`
+		Errors []FieldError `json:"errors"`
+	}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal("Requête incorrecte", problem.Title)
+	if assert.Len(problem.Errors, 3) {
+		assert.Equal("est obligatoire", problem.Errors[0].Message)
+		assert.Equal("doit être compris entre 0 et 150", problem.Errors[1].Message)
+		// custom rules have nothing to translate them with
+		assert.Equal("is too weak", problem.Errors[2].Message)
+	}
+
+	// the error itself is untouched
+	assert.Equal("is required", v.Fields[0].Message)
+
+	// typed problems are titled after their code
+	r.Header.Set("Accept-Language", "es")
+	w = httptest.NewRecorder()
+	HandleError(w, r, v, WithCatalogs(DefaultCatalogs), WithProblemTypeBase("https://example.com/problems"))
+	assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal("la solicitud contenía valores no válidos", problem.Title)
+	assert.Equal("es obligatorio", problem.Errors[0].Message)
+}
+
+func TestLocaleChain(t *testing.T) {
+	tests := []struct {
+		header string
+		chain  []string
+	}{
+		{"", nil},
+		{"fr-CA", []string{"fr-ca", "fr"}},
+		{"fr-CA, en;q=0.5", []string{"fr-ca", "fr", "en"}},
+		{"en;q=0.2, es-MX;q=0.8, *", []string{"es-mx", "es", "en"}},
+		{"zh-Hant-TW, fr;q=0", []string{"zh-hant-tw", "zh-hant", "zh"}},
+		{"en_GB, en-US", []string{"en-gb", "en", "en-us"}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.chain, LocaleChain(tt.header), tt.header)
+	}
+}
+
+func TestCatalogFallsBackOnBadParams(t *testing.T) {
+	c := NewCatalogs("en")
+	assert.NoError(t, c.Add("en", map[ErrorCode]string{"THING": "default"}))
+	assert.NoError(t, c.Add("fr", map[ErrorCode]string{"THING": "{{.missing}}"}))
+
+	msg, locale, ok := c.Message([]string{"fr"}, "THING", nil)
+	assert.True(t, ok)
+	assert.Equal(t, "default", msg)
+	assert.Equal(t, "en", locale)
+}
+
+func TestCatalogRejectsBadTemplates(t *testing.T) {
+	assert.Error(t, NewCatalogs("en").Add("en", map[ErrorCode]string{"THING": "{{"}))
+}
+
+func TestHandleErrorLocalizes(t *testing.T) {
+	v := &ValidationError{}
+	v.Required("name", "")
+	v.Required("email", "")
+
+	tests := []struct {
+		name           string
+		err            error
+		acceptLanguage string
+		message        string
+		language       string
+	}{
+		{"exact", v, "fr", "2 valeurs sont invalides", "fr"},
+		{"region falls back to language", &UniqueConstraintViolatedError{}, "es-MX", "no se puede guardar: ya existe un registro con el mismo identificador", "es"},
+		{"unsupported falls back to default", &UniqueConstraintViolatedError{}, "de-DE, ja;q=0.5", "cannot save record because another exists with the same ID", "en"},
+		{"generic message is localized", assert.AnError, "fr-CA", "une erreur inattendue s'est produite", "fr"},
+		// this error picked its own message and has no code to look up
+		{"uncoded errors keep their message", &rateLimitedError{}, "fr", "slow down", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := httptest.NewRecorder()
+			HandleError(w, r, tt.err, WithCatalogs(DefaultCatalogs), WithErrorLog(log.New(io.Discard, "", 0)))
+
+			var problem Problem
+			assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+			assert.Equal(tt.message, problem.Detail)
+			assert.Equal(tt.language, w.Header().Get("Content-Language"))
+			assert.Equal([]string{"Accept", "Accept-Language"}, w.Header().Values("Vary"))
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// HandlerFunc is an HTTP handler that returns its errors rather than
+// writing them, so that every handler reports errors the same way.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// ServeHTTP implements http.Handler for HandlerFunc, using
+// HandleError's default options. Use Handler to change them.
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, f, newHandlerConfig(nil), nil)
+}
+
+// Handler adapts f to an http.Handler. Errors returned by f are written
+// with HandleError, using opts, and panics become 500 responses.
+func Handler(f HandlerFunc, opts ...HandlerOption) http.Handler {
+	cfg := newHandlerConfig(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, f, cfg, opts)
+	})
+}
+
+// Recoverer is middleware that turns panics in next into 500
+// responses, for handlers that don't return errors.
+func Recoverer(next http.Handler, opts ...HandlerOption) http.Handler {
+	return Handler(func(w http.ResponseWriter, r *http.Request) error {
+		next.ServeHTTP(w, r)
+		return nil
+	}, opts...)
+}
+
+// serve runs f and routes whatever goes wrong through HandleError.
+func serve(w http.ResponseWriter, r *http.Request, f HandlerFunc, cfg handlerConfig, opts []HandlerOption) {
+	tw := &trackingWriter{ResponseWriter: w}
+
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		// the server uses this to abort a response on purpose,
+		// it isn't a bug and shouldn't be reported as one.
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+
+		handle(tw, r, &panicError{value: v, stack: debug.Stack()}, cfg, opts)
+	}()
+
+	if err := f(tw, r); err != nil {
+		handle(tw, r, err, cfg, opts)
+	}
+}
+
+// handle writes err, unless the handler already started a response.
+// Either way it's reported to the observers.
+func handle(tw *trackingWriter, r *http.Request, err error, cfg handlerConfig, opts []HandlerOption) {
+	if tw.wroteHeader {
+		// the status and maybe part of the body are already on their way
+		// to the client, writing an error now would corrupt the response.
+		// Nobody will see a correlation ID, so there's no point making one.
+		logError(cfg.errorLog, "error after response started", "", err)
+
+		// the status is the one the error would have had, the
+		// response says otherwise but it's still a failure
+		cfg.observe(ErrorEvent{
+			Status: statusOf(err),
+			Code:   codeOf(err),
+			Route:  routeOf(r, cfg),
+			Err:    err,
+		})
+		return
+	}
+
+	HandleError(tw, r, err, opts...)
+}
+
+// trackingWriter records whether a response has been started.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter for trackingWriter.
+func (t *trackingWriter) WriteHeader(code int) {
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter for trackingWriter.
+func (t *trackingWriter) Write(b []byte) (int, error) {
+	// an implicit 200 is sent on the first write
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the
+// underlying writer, for flushing and deadlines.
+func (t *trackingWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// panicError is a recovered panic.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+// Error implements error interface for panicError.
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// Unwrap lets errors.Is/As see errors passed to panic.
+func (e *panicError) Unwrap() error {
+	err, _ := e.value.(error)
+	return err
+}
+
+// StackTrace implements stackTracer for panicError.
+func (e *panicError) StackTrace() string {
+	return string(e.stack)
+}
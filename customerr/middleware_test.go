@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerFuncWritesErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	h := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return &UniqueConstraintViolatedError{}
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/widgets", nil))
+
+	var problem Problem
+	assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.Equal("/widgets", problem.Instance)
+}
+
+func TestHandlerFuncSuccess(t *testing.T) {
+	h := HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/widgets", nil))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestHandlerRecoversPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	h := Handler(func(w http.ResponseWriter, r *http.Request) error {
+		panic("nil map somewhere")
+	}, WithErrorLog(log.New(&logs, "", 0)), WithLegacyFormat())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var resp ErrorResponse
+	assert.NoError(json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal(GenericMessage, resp.Message)
+	assert.Contains(logs.String(), "panic: nil map somewhere")
+	// the stack should point at the handler that panicked
+	assert.Contains(logs.String(), "TestHandlerRecoversPanics")
+}
+
+func TestRecovererWrapsPlainHandlers(t *testing.T) {
+	h := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(errors.New("oops"))
+	}), WithErrorLog(log.New(io.Discard, "", 0)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestHandlerErrorAfterHeadersSent(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	var events []ErrorEvent
+	h := Handler(func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("partial"))
+		return &ValidationError{}
+	}, WithErrorLog(log.New(&logs, "", 0)), WithRoute("GET /things"),
+		WithObserver(ObserverFunc(func(e ErrorEvent) { events = append(events, e) })))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// the original response is untouched, and the error only logged
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("partial", w.Body.String())
+	assert.Contains(logs.String(), "error after response started")
+	// there's no one to quote an ID to
+	assert.NotContains(logs.String(), "correlation_id")
+
+	// but it still shows up in the metrics
+	if assert.Len(events, 1) {
+		assert.Equal(http.StatusBadRequest, events[0].Status)
+		assert.Equal(CodeValidationFailed, events[0].Code)
+		assert.Equal("GET /things", events[0].Route)
+		assert.Empty(events[0].CorrelationID)
+	}
+}
+
+func TestHandlerRepanicsAbort(t *testing.T) {
+	h := Handler(func(w http.ResponseWriter, r *http.Request) error {
+		panic(http.ErrAbortHandler)
+	})
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
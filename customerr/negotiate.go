@@ -0,0 +1,154 @@
+package main
+
+import (
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mediaEncoder pairs an Encoder with a media type that clients
+// can ask for. The same Encoder can be offered under several types.
+type mediaEncoder struct {
+	mediaType string
+	enc       Encoder
+}
+
+// defaultEncoders are the formats HandleError offers out of the box.
+// Order matters: wildcard ranges like "application/*" pick the first match.
+// Plain application/json isn't here, it gets the fallback, see negotiate.
+func defaultEncoders() []mediaEncoder {
+	return []mediaEncoder{
+		{ProblemContentType, ProblemJSONEncoder{}},
+		{"application/problem+xml", XMLEncoder{}},
+		{"application/xml", XMLEncoder{}},
+		{"text/xml", XMLEncoder{}},
+		{"text/plain", TextEncoder{}},
+		{GRPCStatusContentType, GRPCStatusJSONEncoder{}},
+	}
+}
+
+// WithEncoder offers enc to clients that accept mediaType, replacing
+// any encoder already registered for it.
+func WithEncoder(mediaType string, enc Encoder) HandlerOption {
+	return func(c *handlerConfig) {
+		mediaType = strings.ToLower(mediaType)
+		for i, me := range c.encoders {
+			if me.mediaType == mediaType {
+				c.encoders[i].enc = enc
+				return
+			}
+		}
+
+		c.encoders = append(c.encoders, mediaEncoder{mediaType, enc})
+	}
+}
+
+// acceptRange is a single entry in an Accept header.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// specificity ranks "type/subtype" over "type/*" over "*/*".
+func (a acceptRange) specificity() int {
+	switch {
+	case a.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(a.mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// parseAccept parses an Accept header into its media ranges, most
+// preferred first. Ranges the client refuses (q=0) or that can't be
+// parsed are dropped.
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !strings.Contains(mediaType, "/") {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, acceptRange{mediaType, q})
+	}
+
+	// stable, so ties keep the order the client listed them in
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+
+	return ranges
+}
+
+// fallback is the encoder used when the client will take anything,
+// or doesn't accept anything we can produce.
+func (c handlerConfig) fallback() mediaEncoder {
+	if c.legacy {
+		return mediaEncoder{"application/json", JSONEncoder{}}
+	}
+
+	return mediaEncoder{ProblemContentType, ProblemJSONEncoder{}}
+}
+
+// negotiate picks the encoder that best matches the Accept header.
+//
+// NOTE: a strict server would reply 406 Not Acceptable when nothing
+// matches, but an error response in the wrong format is more useful
+// to a client than a second error about the format.
+func (c handlerConfig) negotiate(accept string) Encoder {
+	fallback := c.fallback()
+
+	for _, r := range parseAccept(accept) {
+		if r.mediaType == "*/*" {
+			return fallback.enc
+		}
+
+		if prefix, ok := strings.CutSuffix(r.mediaType, "*"); ok {
+			// prefer the fallback if it fits the range,
+			// so "application/*" doesn't change the format.
+			if strings.HasPrefix(fallback.mediaType, prefix) {
+				return fallback.enc
+			}
+
+			for _, me := range c.encoders {
+				if strings.HasPrefix(me.mediaType, prefix) {
+					return me.enc
+				}
+			}
+			continue
+		}
+
+		for _, me := range c.encoders {
+			if me.mediaType == r.mediaType {
+				return me.enc
+			}
+		}
+
+		// problem+json is JSON too, and most clients just ask for
+		// JSON, so they only get the old format with WithLegacyFormat
+		if r.mediaType == "application/json" {
+			return fallback.enc
+		}
+	}
+
+	return fallback.enc
+}
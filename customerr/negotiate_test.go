@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccept(t *testing.T) {
+	ranges := parseAccept("text/*;q=0.5, application/xml;q=0, */*;q=0.1, text/plain, application/json, bogus")
+
+	var got []string
+	for _, r := range ranges {
+		got = append(got, r.mediaType)
+	}
+
+	assert.Equal(t, []string{"text/plain", "application/json", "text/*", "*/*"}, got)
+}
+
+func TestHandleErrorNegotiates(t *testing.T) {
+	tests := []struct {
+		accept      string
+		legacy      bool
+		contentType string
+	}{
+		{"", false, ProblemContentType},
+		{"*/*", false, ProblemContentType},
+		{"", true, "application/json"},
+		{"*/*", true, "application/json"},
+		{"application/json", false, ProblemContentType},
+		{"application/json", true, "application/json"},
+		{"application/problem+json", true, ProblemContentType},
+		{"application/*", false, ProblemContentType},
+		{"application/*", true, "application/json"},
+		{"text/plain", false, "text/plain; charset=utf-8"},
+		{"text/*", false, "application/problem+xml"},
+		{"application/xml", false, "application/problem+xml"},
+		{"text/html, text/plain;q=0.9", false, "text/plain; charset=utf-8"},
+		{"text/plain;q=0.2, application/json;q=0.8", false, ProblemContentType},
+		{"text/plain;q=0.2, application/json;q=0.8", true, "application/json"},
+		{"image/png", false, ProblemContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s legacy=%t", tt.accept, tt.legacy), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			var opts []HandlerOption
+			if tt.legacy {
+				opts = append(opts, WithLegacyFormat())
+			}
+
+			w := httptest.NewRecorder()
+			HandleError(w, r, &UniqueConstraintViolatedError{}, opts...)
+
+			assert.Equal(t, http.StatusConflict, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		})
+	}
+}
+
+func TestHandleErrorText(t *testing.T) {
+	v := &ValidationError{}
+	v.Required("name", "")
+
+	r := httptest.NewRequest(http.MethodPost, "/users", nil)
+	r.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	HandleError(w, r, v)
+
+	assert.Equal(t, "VALIDATION_FAILED: the provided inputs are invalid for the following reasons: [name is required]\n  - name is required\n", w.Body.String())
+}
+
+func TestHandleErrorXML(t *testing.T) {
+	assert := assert.New(t)
+
+	v := &ValidationError{}
+	v.MinLength("name", "a", 2)
+
+	r := httptest.NewRequest(http.MethodPost, "/users", nil)
+	r.Header.Set("Accept", "application/xml")
+	w := httptest.NewRecorder()
+	HandleError(w, r, v)
+
+	var got xmlProblem
+	assert.NoError(xml.NewDecoder(w.Body).Decode(&got))
+	assert.Equal("urn:ietf:rfc:7807", got.XMLName.Space)
+	assert.Equal(http.StatusBadRequest, got.Status)
+	assert.Equal("/users", got.Instance)
+	assert.Equal(CodeValidationFailed, got.Code)
+	assert.Equal([]xmlField{
+		{Field: "name", Rule: RuleMinLength, Value: "a", Message: "must be at least 2 characters"},
+	}, got.Errors)
+}
+
+func TestHandleErrorGRPCStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	v := &ValidationError{}
+	v.Required("name", "")
+
+	r := httptest.NewRequest(http.MethodPost, "/users", nil)
+	r.Header.Set("Accept", "text/html, "+GRPCStatusContentType)
+	w := httptest.NewRecorder()
+	HandleError(w, r, v)
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal(GRPCStatusContentType, w.Header().Get("Content-Type"))
+	assert.JSONEq(`{
+		"code": 3,
+		"message": "the provided inputs are invalid for the following reasons: [name is required]",
+		"details": [
+			{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "VALIDATION_FAILED"},
+			{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [
+				{"field": "name", "description": "is required"}
+			]}
+		]
+	}`, w.Body.String())
+
+	// server errors keep their correlation ID, and the code
+	// comes from the error when it knows it
+	r.Header.Set("Accept", GRPCStatusContentType)
+	w = httptest.NewRecorder()
+	HandleError(w, r, &notFoundError{})
+
+	var s grpcStatus
+	assert.NoError(json.NewDecoder(w.Body).Decode(&s))
+	assert.Equal(GRPCNotFound, s.Code)
+
+	w = httptest.NewRecorder()
+	HandleError(w, r, errors.New("connection reset"), WithErrorLog(log.New(io.Discard, "", 0)))
+	assert.NoError(json.NewDecoder(w.Body).Decode(&s))
+	assert.Equal(GRPCInternal, s.Code)
+	assert.Equal(GenericMessage, s.Message)
+	assert.Contains(s.Details[0], "metadata")
+}
+
+// csvEncoder shows a format plugged in by the application.
+type csvEncoder struct{}
+
+func (csvEncoder) ContentType() string {
+	return "text/csv"
+}
+
+func (csvEncoder) Encode(w io.Writer, d ErrorDetails) error {
+	_, err := fmt.Fprintf(w, "%d,%s\n", d.Status, d.Code)
+	return err
+}
+
+func TestHandleErrorCustomEncoder(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	HandleError(w, r, &UniqueConstraintViolatedError{}, WithEncoder("text/csv", csvEncoder{}))
+
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "409,UNIQUE_VIOLATION\n", w.Body.String())
+}
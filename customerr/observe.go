@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrorEvent describes an error that HandleError responded to.
+type ErrorEvent struct {
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// Code is the application error code of the response.
+	Code ErrorCode
+
+	// Route identifies the endpoint, see WithRoute.
+	Route string
+
+	// CorrelationID is set for server errors, and ties the event to the log.
+	CorrelationID string
+
+	// Err is the error that was handled, with all its internal details.
+	Err error
+}
+
+// Observer is told about every error HandleError responds to. Observers
+// are called synchronously, so they should be quick.
+type Observer interface {
+	ObserveError(e ErrorEvent)
+}
+
+// ObserverFunc adapts a function to an Observer.
+type ObserverFunc func(e ErrorEvent)
+
+// ObserveError implements Observer for ObserverFunc.
+func (f ObserverFunc) ObserveError(e ErrorEvent) {
+	f(e)
+}
+
+// WithObserver adds o to the observers HandleError notifies.
+func WithObserver(o Observer) HandlerOption {
+	return func(c *handlerConfig) {
+		c.observers = append(c.observers, o)
+	}
+}
+
+// observe tells the observers about e.
+func (c handlerConfig) observe(e ErrorEvent) {
+	for _, o := range c.observers {
+		o.ObserveError(e)
+	}
+}
+
+// WithRoute sets the route reported to observers. Without it the request
+// path is used, which can give metrics a lot of distinct values if paths
+// contain IDs, so prefer the route pattern, e.g. "GET /widgets/{id}".
+func WithRoute(route string) HandlerOption {
+	return func(c *handlerConfig) {
+		c.route = route
+	}
+}
+
+// routeOf returns the route to report for r.
+func routeOf(r *http.Request, cfg handlerConfig) string {
+	if cfg.route != "" || r == nil {
+		return cfg.route
+	}
+
+	return r.URL.Path
+}
+
+// CounterKey is what Counters counts errors by.
+type CounterKey struct {
+	Route  string
+	Status int
+	Code   ErrorCode
+}
+
+// Counters is an Observer that counts errors by route, status and code.
+// It's a stand in for a metrics library, and is safe for concurrent use.
+type Counters struct {
+	mu     sync.Mutex
+	counts map[CounterKey]int64
+}
+
+// NewCounters creates an empty set of counters.
+func NewCounters() *Counters {
+	return &Counters{counts: make(map[CounterKey]int64)}
+}
+
+// ObserveError implements Observer for Counters.
+func (c *Counters) ObserveError(e ErrorEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[CounterKey{Route: e.Route, Status: e.Status, Code: e.Code}]++
+}
+
+// Counts returns a copy of the counts.
+func (c *Counters) Counts() map[CounterKey]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[CounterKey]int64, len(c.counts))
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+
+	return counts
+}
+
+// ByStatus totals the counts for each status code.
+func (c *Counters) ByStatus() map[int]int64 {
+	totals := make(map[int]int64)
+	for k, v := range c.Counts() {
+		totals[k.Status] += v
+	}
+
+	return totals
+}
+
+// ByCode totals the counts for each error code.
+func (c *Counters) ByCode() map[ErrorCode]int64 {
+	totals := make(map[ErrorCode]int64)
+	for k, v := range c.Counts() {
+		totals[k.Code] += v
+	}
+
+	return totals
+}
+
+// Keys lists the keys that have been counted, sorted by route, status and code.
+func (c *Counters) Keys() []CounterKey {
+	counts := c.Counts()
+
+	keys := make([]CounterKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		return a.Code < b.Code
+	})
+
+	return keys
+}
+
+// ErrorReport is the full picture of a sampled server error,
+// for sending to an error tracker.
+type ErrorReport struct {
+	ErrorEvent
+
+	// Time is when the error was handled.
+	Time time.Time
+
+	// Chain describes every error in the chain, outermost first.
+	Chain []string
+
+	// Stack is the deepest stack trace captured in the chain, if any.
+	Stack string
+}
+
+// SamplingReporter is an Observer that passes a fraction of server errors
+// to an error tracker, with their full chains. Client errors are ignored,
+// they're the caller's problem and counting them is usually enough.
+type SamplingReporter struct {
+	rate   float64
+	report func(ErrorReport)
+
+	// random returns a number in [0, 1), it's replaced in tests.
+	random func() float64
+	now    func() time.Time
+}
+
+// NewSamplingReporter creates a SamplingReporter that passes roughly rate
+// (between 0 and 1) of server errors to report.
+func NewSamplingReporter(rate float64, report func(ErrorReport)) *SamplingReporter {
+	return &SamplingReporter{
+		rate:   rate,
+		report: report,
+		random: rand.Float64,
+		now:    time.Now,
+	}
+}
+
+// ObserveError implements Observer for SamplingReporter.
+func (s *SamplingReporter) ObserveError(e ErrorEvent) {
+	if e.Status < http.StatusInternalServerError {
+		return
+	}
+
+	if s.random() >= s.rate {
+		return
+	}
+
+	report := ErrorReport{
+		ErrorEvent: e,
+		Time:       s.now(),
+		Chain:      errorChain(e.Err),
+	}
+	report.Stack, _ = deepestStack(e.Err)
+
+	s.report(report)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var quietLog = WithErrorLog(log.New(io.Discard, "", 0))
+
+func TestObserverSeesEveryError(t *testing.T) {
+	assert := assert.New(t)
+
+	var events []ErrorEvent
+	observer := WithObserver(ObserverFunc(func(e ErrorEvent) {
+		events = append(events, e)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/users/42", nil)
+	HandleError(httptest.NewRecorder(), r, &UniqueConstraintViolatedError{}, observer)
+	HandleError(httptest.NewRecorder(), r, errors.New("boom"), observer, quietLog, WithRoute("POST /users/{id}"))
+
+	if assert.Len(events, 2) {
+		assert.Equal(http.StatusConflict, events[0].Status)
+		assert.Equal(CodeUniqueViolation, events[0].Code)
+		assert.Equal("/users/42", events[0].Route)
+		assert.Empty(events[0].CorrelationID)
+
+		assert.Equal(http.StatusInternalServerError, events[1].Status)
+		assert.Equal(CodeUnknown, events[1].Code)
+		assert.Equal("POST /users/{id}", events[1].Route)
+		assert.NotEmpty(events[1].CorrelationID)
+		assert.EqualError(events[1].Err, "boom")
+	}
+}
+
+func TestCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	counters := NewCounters()
+	opts := []HandlerOption{WithObserver(counters), WithRoute("GET /widgets"), quietLog}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var err error = &UniqueConstraintViolatedError{}
+			if i%2 == 0 {
+				err = errors.New("boom")
+			}
+			HandleError(httptest.NewRecorder(), nil, err, opts...)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(map[int]int64{http.StatusConflict: 5, http.StatusInternalServerError: 5}, counters.ByStatus())
+	assert.Equal(map[ErrorCode]int64{CodeUniqueViolation: 5, CodeUnknown: 5}, counters.ByCode())
+	assert.Equal([]CounterKey{
+		{Route: "GET /widgets", Status: http.StatusConflict, Code: CodeUniqueViolation},
+		{Route: "GET /widgets", Status: http.StatusInternalServerError, Code: CodeUnknown},
+	}, counters.Keys())
+}
+
+func TestSamplingReporter(t *testing.T) {
+	assert := assert.New(t)
+
+	var reports []ErrorReport
+	reporter := NewSamplingReporter(0.25, func(r ErrorReport) {
+		reports = append(reports, r)
+	})
+
+	// a fixed sequence, so exactly the first of every four is sampled
+	rolls := []float64{0.1, 0.5, 0.9, 0.3}
+	calls := 0
+	reporter.random = func() float64 {
+		roll := rolls[calls%len(rolls)]
+		calls++
+		return roll
+	}
+
+	err := Wrap(errors.New("connection reset"), "loading profile", "id", 7)
+	for i := 0; i < 8; i++ {
+		HandleError(httptest.NewRecorder(), nil, err, WithObserver(reporter), quietLog)
+	}
+
+	// client errors are never sampled, and don't use up a roll
+	HandleError(httptest.NewRecorder(), nil, &ValidationError{}, WithObserver(reporter))
+
+	assert.Equal(8, calls)
+	if assert.Len(reports, 2) {
+		report := reports[0]
+		assert.Equal(http.StatusInternalServerError, report.Status)
+		assert.NotEmpty(report.CorrelationID)
+		assert.False(report.Time.IsZero())
+		if assert.Len(report.Chain, 2) {
+			assert.Contains(report.Chain[0], "loading profile id=7")
+			assert.Equal("*errors.errorString: connection reset", report.Chain[1])
+		}
+		assert.Contains(report.Stack, "observe_test.go")
+	}
+}
+
+func TestSamplingReporterRateBounds(t *testing.T) {
+	assert := assert.New(t)
+
+	count := func(rate float64) int {
+		n := 0
+		reporter := NewSamplingReporter(rate, func(ErrorReport) { n++ })
+		for i := 0; i < 100; i++ {
+			reporter.ObserveError(ErrorEvent{Status: http.StatusServiceUnavailable, Err: errors.New("down")})
+		}
+		return n
+	}
+
+	assert.Equal(0, count(0))
+	assert.Equal(100, count(1))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// ProblemContentType is the media type for Problem Details documents.
+const ProblemContentType = "application/problem+json"
+
+// Problem is a Problem Details document as described by
+// RFC 9457 (which obsoletes RFC 7807).
+//
+// See https://www.rfc-editor.org/rfc/rfc9457 for what each member means.
+type Problem struct {
+	// Type is a URI identifying the kind of problem.
+	Type string `json:"type,omitempty"`
+
+	// Title is a short summary of the kind of problem.
+	Title string `json:"title,omitempty"`
+
+	// Status is the HTTP status code of the response.
+	Status int `json:"status,omitempty"`
+
+	// Detail explains this particular occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is a URI identifying this particular occurrence of the problem.
+	Instance string `json:"instance,omitempty"`
+
+	// Extensions are serialized as additional top level members.
+	// They can't override the members above.
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON flattens Extensions into the document, as the RFC requires.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	// the alias drops Problem's methods so we don't recurse
+	type problem Problem
+	base, err := json.Marshal(problem(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return base, err
+	}
+
+	members := make(map[string]any, len(p.Extensions))
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+
+	// unmarshal the standard members over the top so
+	// extensions can't clobber them.
+	if err := json.Unmarshal(base, &members); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(members)
+}
+
+// ProblemExtender lets an error add extension members to the
+// Problem Details response created for it.
+type ProblemExtender interface {
+	// ProblemExtensions returns the members to add to the response.
+	ProblemExtensions() map[string]any
+}
+
+// NewProblem builds the Problem Details document for d.
+func NewProblem(d ErrorDetails) Problem {
+	p := Problem{
+		Type:       d.Type,
+		Title:      d.Title,
+		Status:     d.Status,
+		Detail:     d.Message,
+		Instance:   d.Instance,
+		Extensions: make(map[string]any, len(d.Extensions)+2),
+	}
+
+	for k, v := range d.Extensions {
+		p.Extensions[k] = v
+	}
+
+	// set after the error's own extensions, these are part of our
+	// contract and errors shouldn't be able to change them.
+	p.Extensions["code"] = d.Code
+	if d.CorrelationID != "" {
+		p.Extensions["correlationId"] = d.CorrelationID
+	}
+
+	return p
+}
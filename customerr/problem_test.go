@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rateLimitedError is a status aware error that adds
+// its own members to the problem response.
+type rateLimitedError struct {
+	retryAfter int
+}
+
+func (e *rateLimitedError) Error() string {
+	return "slow down"
+}
+
+func (e *rateLimitedError) Status() int {
+	return http.StatusTooManyRequests
+}
+
+func (e *rateLimitedError) ProblemExtensions() map[string]any {
+	return map[string]any{
+		"retryAfter": e.retryAfter,
+		// neither of these should make it into the response
+		"status": 200,
+		"code":   "NOT_A_REAL_CODE",
+	}
+}
+
+func TestHandleErrorProblemDetails(t *testing.T) {
+	assert := assert.New(t)
+
+	validation := &ValidationError{}
+	validation.Required("name", "")
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, validation, WithInstance("/widgets/1"))
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal(ProblemContentType, w.Header().Get("Content-Type"))
+	assert.JSONEq(`{
+		"title": "Bad Request",
+		"status": 400,
+		"detail": "the provided inputs are invalid for the following reasons: [name is required]",
+		"instance": "/widgets/1",
+		"code": "VALIDATION_FAILED",
+		"errors": [{"field": "name", "rule": "required", "message": "is required"}]
+	}`, w.Body.String())
+}
+
+func TestHandleErrorProblemType(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, &UniqueConstraintViolatedError{}, WithProblemTypeBase("https://example.com/problems/"))
+
+	var problem Problem
+	assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal("https://example.com/problems/unique-violation", problem.Type)
+	assert.Equal("a record with the same unique key already exists", problem.Title)
+}
+
+func TestHandleErrorProblemExtensions(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, &rateLimitedError{retryAfter: 30})
+
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.JSONEq(`{
+		"title": "Too Many Requests",
+		"status": 429,
+		"detail": "slow down",
+		"code": "UNKNOWN",
+		"retryAfter": 30
+	}`, w.Body.String())
+}
+
+func TestHandleErrorLegacyFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, &UniqueConstraintViolatedError{}, WithLegacyFormat())
+
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(`{
+		"Code": 409,
+		"ErrorCode": "UNIQUE_VIOLATION",
+		"Message": "cannot save record because another exists with the same ID"
+	}`, w.Body.String())
+}
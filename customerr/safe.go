@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// GenericMessage is shown to callers in place of any message
+// that isn't known to be safe for them to see.
+const GenericMessage = "an unexpected error occurred"
+
+// PublicError can describe itself in a way that's safe to show to
+// the caller, separately from Error, which is free to include
+// internal details for logs (queries, IDs, hostnames, etc.).
+type PublicError interface {
+	// PublicErrors must also be errors.
+	error
+
+	// PublicMessage returns a message that's safe to show to callers.
+	PublicMessage() string
+}
+
+// publicMessage picks the message to show the caller for err, and reports
+// whether it came from the error rather than being GenericMessage.
+// Errors that opt in with PublicError get to choose. Otherwise a
+// StatusAwareError is trusted to describe itself for client errors, but not
+// for server errors, where Error is most likely to contain details about
+// our internals.
+//
+// Note that it's the status aware error's own message that's used, not
+// err.Error(), so context added by wrapping it on the way up doesn't leak.
+func publicMessage(err error, status int) (string, bool) {
+	var public PublicError
+	if errors.As(err, &public) {
+		return public.PublicMessage(), true
+	}
+
+	if status >= http.StatusInternalServerError {
+		return GenericMessage, false
+	}
+
+	var statusAware StatusAwareError
+	if errors.As(err, &statusAware) {
+		return statusAware.Error(), true
+	}
+
+	return GenericMessage, false
+}
+
+// newCorrelationID returns a random ID to tie a response to its log entry.
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// not worth failing the response over, the log
+		// entry can still be found by time.
+		return "unavailable"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// errorChain describes every error in err's tree, outermost first,
+// following both Unwrap() error and Unwrap() []error.
+func errorChain(err error) []string {
+	var chain []string
+
+	var walk func(error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+
+		if d, ok := err.(linkDescriber); ok {
+			chain = append(chain, d.describeLink())
+		} else {
+			chain = append(chain, fmt.Sprintf("%T: %s", err, err))
+		}
+
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		}
+	}
+	walk(err)
+
+	return chain
+}
+
+// stackTracer is implemented by errors that captured
+// the stack at the point they were created.
+type stackTracer interface {
+	StackTrace() string
+}
+
+// logError records the full details of err against correlationID, so
+// the generic response a caller sees can be traced back to its cause.
+// Client errors have no correlation ID, so it's left out if it's empty.
+func logError(l *log.Logger, msg, correlationID string, err error) {
+	if correlationID != "" {
+		msg += " correlation_id=" + correlationID
+	}
+
+	l.Printf("%s error=%q chain=%q", msg, err.Error(), errorChain(err))
+
+	if stack, ok := deepestStack(err); ok {
+		l.Printf("%s stack=%q", msg, stack)
+	}
+}
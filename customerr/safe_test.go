@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// outageError is a server error that knows how to describe itself safely.
+type outageError struct{}
+
+func (e *outageError) Error() string {
+	return "primary db at 10.0.0.7 is down"
+}
+
+func (e *outageError) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e *outageError) PublicMessage() string {
+	return "we're having trouble right now, try again shortly"
+}
+
+func TestHandleErrorHidesUnknownErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	err := fmt.Errorf("saving widget: %w", errors.New("password authentication failed for user admin"))
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, err, WithErrorLog(log.New(&logs, "", 0)))
+
+	var problem map[string]any
+	assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal(GenericMessage, problem["detail"])
+	assert.NotContains(w.Body.String(), "password")
+
+	// the correlation ID in the response should lead to the full chain in the logs
+	id, ok := problem["correlationId"].(string)
+	assert.True(ok)
+	assert.NotEmpty(id)
+	assert.Contains(logs.String(), "correlation_id="+id)
+	assert.Contains(logs.String(), "*fmt.wrapError: saving widget")
+	assert.Contains(logs.String(), "*errors.errorString: password authentication failed")
+}
+
+func TestHandleErrorPublicMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	w := httptest.NewRecorder()
+	HandleError(w, nil, &outageError{}, WithErrorLog(log.New(&logs, "", 0)), WithLegacyFormat())
+
+	var resp ErrorResponse
+	assert.NoError(json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal("we're having trouble right now, try again shortly", resp.Message)
+	assert.NotEmpty(resp.CorrelationID)
+	assert.Contains(logs.String(), "primary db at 10.0.0.7 is down")
+}
+
+func TestHandleErrorDropsWrappingContext(t *testing.T) {
+	assert := assert.New(t)
+
+	err := fmt.Errorf("inserting into users (id=42): %w", &UniqueConstraintViolatedError{})
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, err)
+
+	var problem Problem
+	assert.NoError(json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal("cannot save record because another exists with the same ID", problem.Detail)
+	// client errors don't need tracing back
+	assert.NotContains(w.Body.String(), "correlationId")
+}
+
+func TestErrorChainFollowsJoins(t *testing.T) {
+	first := errors.New("first")
+	second := fmt.Errorf("second: %w", errors.New("cause"))
+
+	chain := errorChain(errors.Join(first, second))
+
+	assert.Equal(t, []string{
+		"*errors.joinError: first\nsecond: cause",
+		"*errors.errorString: first",
+		"*fmt.wrapError: second: cause",
+		"*errors.errorString: cause",
+	}, chain)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rule names used by the ValidationError builders. Like error codes,
+// clients may branch on these, so they shouldn't change.
+const (
+	RuleRequired  = "required"
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleRange     = "range"
+	RuleOneOf     = "one_of"
+	RuleInvalid   = "invalid"
+)
+
+// FieldError describes a single input that failed validation.
+type FieldError struct {
+	// Field is the path to the input, e.g. "address.street" or "items[2].sku".
+	Field string `json:"field"`
+
+	// Rule is the name of the rule the input broke.
+	Rule string `json:"rule"`
+
+	// Value is the rejected input, if there was one.
+	Value any `json:"value,omitempty"`
+
+	// Message explains the rule in terms a caller can act on.
+	Message string `json:"message"`
+
+	// cause is the error merged in for the field, if that's where it
+	// came from. It's only for logs, it might not be safe to show.
+	cause error
+
+	// params fill in the localized message for Rule. They're only set
+	// by the built in rules, messages for custom ones are left as they are.
+	params map[string]any
+}
+
+// Error implements error interface for FieldError.
+func (f FieldError) Error() string {
+	if f.cause != nil {
+		return f.publicMessage() + ": " + f.cause.Error()
+	}
+
+	return f.publicMessage()
+}
+
+// Unwrap returns the error merged in for the field, if there was one.
+func (f FieldError) Unwrap() error {
+	return f.cause
+}
+
+// publicMessage describes the field without its cause.
+func (f FieldError) publicMessage() string {
+	return f.Field + " " + f.Message
+}
+
+// Add records a failed field.
+func (e *ValidationError) Add(field FieldError) {
+	e.Fields = append(e.Fields, field)
+}
+
+// Check records a failed field for a custom rule if ok is false, and
+// returns ok so that dependent checks can be skipped. The message
+// isn't localized, as there's no catalog entry for a custom rule.
+func (e *ValidationError) Check(ok bool, field, rule string, value any, message string) bool {
+	return e.checkRule(ok, field, rule, value, message, nil)
+}
+
+// checkRule is Check for the built in rules, with the
+// params for their localized messages.
+func (e *ValidationError) checkRule(ok bool, field, rule string, value any, message string, params map[string]any) bool {
+	if !ok {
+		e.Add(FieldError{Field: field, Rule: rule, Value: value, Message: message, params: params})
+	}
+
+	return ok
+}
+
+// Required checks that value isn't its type's zero value.
+func (e *ValidationError) Required(field string, value any) bool {
+	ok := value != nil && !reflect.ValueOf(value).IsZero()
+	// the zero value isn't worth echoing back
+	return e.checkRule(ok, field, RuleRequired, nil, "is required", map[string]any{})
+}
+
+// MinLength checks that value has at least min characters.
+func (e *ValidationError) MinLength(field, value string, min int) bool {
+	ok := utf8.RuneCountInString(value) >= min
+	return e.checkRule(ok, field, RuleMinLength, value, fmt.Sprintf("must be at least %d characters", min),
+		map[string]any{"min": min})
+}
+
+// MaxLength checks that value has at most max characters.
+func (e *ValidationError) MaxLength(field, value string, max int) bool {
+	ok := utf8.RuneCountInString(value) <= max
+	return e.checkRule(ok, field, RuleMaxLength, value, fmt.Sprintf("must be at most %d characters", max),
+		map[string]any{"max": max})
+}
+
+// Range checks that min <= value <= max.
+func (e *ValidationError) Range(field string, value, min, max float64) bool {
+	ok := value >= min && value <= max
+	return e.checkRule(ok, field, RuleRange, value, fmt.Sprintf("must be between %v and %v", min, max),
+		map[string]any{"min": min, "max": max})
+}
+
+// OneOf checks that value is one of allowed.
+func (e *ValidationError) OneOf(field, value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+
+	list := strings.Join(allowed, ", ")
+	return e.checkRule(false, field, RuleOneOf, value, "must be one of ["+list+"]",
+		map[string]any{"allowed": list})
+}
+
+// Merge folds the result of validating a nested struct into e, with each
+// of its fields prefixed by prefix. A nil err is ignored, so the result
+// of a nested Validate method can be passed straight in:
+//
+//	v.Merge("address", u.Address.Validate())
+//	v.Merge(fmt.Sprintf("items[%d]", i), item.Validate())
+//
+// Errors that aren't validation errors are recorded against the prefix
+// itself. Their message is only used if they're a PublicError, otherwise
+// it could leak internals to the caller, and they just make the field
+// invalid. Either way the error itself is kept for the logs.
+func (e *ValidationError) Merge(prefix string, err error) {
+	if err == nil {
+		return
+	}
+
+	var nested *ValidationError
+	if !errors.As(err, &nested) {
+		field := FieldError{Field: prefix, Rule: RuleInvalid, Message: "is invalid", cause: err, params: map[string]any{}}
+		var public PublicError
+		if errors.As(err, &public) {
+			// the error chose its message, so it's not the rule's to localize
+			field.Message = public.PublicMessage()
+			field.params = nil
+		}
+		e.Add(field)
+		return
+	}
+
+	for _, f := range nested.Fields {
+		f.Field = joinPath(prefix, f.Field)
+		e.Add(f)
+	}
+}
+
+// Unwrap returns the errors merged into e, so errors.Is and errors.As
+// can find them, and their details, like where they were wrapped,
+// make it into the logs.
+func (e *ValidationError) Unwrap() []error {
+	var causes []error
+	for _, f := range e.Fields {
+		if f.cause != nil {
+			causes = append(causes, f.cause)
+		}
+	}
+
+	return causes
+}
+
+// HasErrors reports whether any fields failed validation.
+func (e *ValidationError) HasErrors() bool {
+	return len(e.Fields) > 0
+}
+
+// Err returns e if any fields failed validation, and nil otherwise.
+// It saves the nil check when returning from a Validate method,
+// and avoids the typed nil pointer in a non-nil error interface trap.
+func (e *ValidationError) Err() error {
+	if !e.HasErrors() {
+		return nil
+	}
+
+	return e
+}
+
+// ProblemExtensions implements ProblemExtender for ValidationError,
+// adding the failed fields to the response.
+func (e *ValidationError) ProblemExtensions() map[string]any {
+	if !e.HasErrors() {
+		return nil
+	}
+
+	return map[string]any{"errors": e.Fields}
+}
+
+// MessageParams implements MessageParamsError for ValidationError.
+func (e *ValidationError) MessageParams() map[string]any {
+	return map[string]any{"count": len(e.Fields)}
+}
+
+// joinPath appends field to prefix, leaving index
+// segments like "[0]" attached to what they index.
+func joinPath(prefix, field string) string {
+	switch {
+	case prefix == "":
+		return field
+	case field == "":
+		return prefix
+	case strings.HasPrefix(field, "["):
+		return prefix + field
+	default:
+		return prefix + "." + field
+	}
+}
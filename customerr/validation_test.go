@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type address struct {
+	Street  string
+	Country string
+}
+
+func (a address) Validate() error {
+	v := &ValidationError{}
+	v.Required("street", a.Street)
+	v.OneOf("country", a.Country, "CA", "US")
+	return v.Err()
+}
+
+type lineItem struct {
+	SKU      string
+	Quantity float64
+}
+
+func (i lineItem) Validate() error {
+	v := &ValidationError{}
+	if v.Required("sku", i.SKU) {
+		v.MaxLength("sku", i.SKU, 8)
+	}
+	v.Range("quantity", i.Quantity, 1, 100)
+	return v.Err()
+}
+
+type order struct {
+	Name    string
+	Address address
+	Items   []lineItem
+}
+
+func (o order) Validate() error {
+	v := &ValidationError{}
+	v.MinLength("name", o.Name, 2)
+	v.Merge("address", o.Address.Validate())
+	for i, item := range o.Items {
+		v.Merge(fmt.Sprintf("items[%d]", i), item.Validate())
+	}
+	return v.Err()
+}
+
+func TestValidationErrorNested(t *testing.T) {
+	assert := assert.New(t)
+
+	o := order{
+		Name:    "x",
+		Address: address{Country: "FR"},
+		Items: []lineItem{
+			{SKU: "ok", Quantity: 1},
+			{SKU: "much-too-long", Quantity: 0},
+		},
+	}
+
+	var validation *ValidationError
+	assert.ErrorAs(o.Validate(), &validation)
+	assert.Equal([]FieldError{
+		{Field: "name", Rule: RuleMinLength, Value: "x", Message: "must be at least 2 characters",
+			params: map[string]any{"min": 2}},
+		{Field: "address.street", Rule: RuleRequired, Message: "is required",
+			params: map[string]any{}},
+		{Field: "address.country", Rule: RuleOneOf, Value: "FR", Message: "must be one of [CA, US]",
+			params: map[string]any{"allowed": "CA, US"}},
+		{Field: "items[1].sku", Rule: RuleMaxLength, Value: "much-too-long", Message: "must be at most 8 characters",
+			params: map[string]any{"max": 8}},
+		{Field: "items[1].quantity", Rule: RuleRange, Value: 0.0, Message: "must be between 1 and 100",
+			params: map[string]any{"min": 1.0, "max": 100.0}},
+	}, validation.Fields)
+}
+
+func TestValidationErrorValid(t *testing.T) {
+	o := order{
+		Name:    "ok",
+		Address: address{Street: "1 Main St", Country: "CA"},
+		Items:   []lineItem{{SKU: "ok", Quantity: 1}},
+	}
+
+	// a nil error, not a nil *ValidationError in an error interface
+	assert.Nil(t, o.Validate())
+}
+
+func TestValidationErrorMergeOtherErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	internal := errors.New("decoding /var/uploads/1234.png: unexpected EOF")
+	public := &NotNullViolatedError{ConstraintViolation{Constraint: "users_avatar_id_not_null", Column: "avatar_id"}}
+
+	v := &ValidationError{}
+	v.Merge("avatar", internal)
+	v.Merge("profile", public)
+
+	if assert.Len(v.Fields, 2) {
+		assert.Equal("is invalid", v.Fields[0].Message)
+		assert.Equal(public.PublicMessage(), v.Fields[1].Message)
+	}
+
+	// the originals are only for the logs
+	assert.NotContains(v.PublicMessage(), "/var/uploads")
+	assert.NotContains(v.PublicMessage(), "avatar_id")
+	assert.Contains(v.Error(), "/var/uploads")
+	assert.ErrorIs(v.Fields[0], internal)
+
+	// in every format
+	for _, accept := range []string{"application/problem+json", "application/json", "application/problem+xml", "text/plain"} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		HandleError(w, r, v)
+		assert.NotContains(w.Body.String(), "/var/uploads", accept)
+		assert.NotContains(w.Body.String(), "avatar_id", accept)
+	}
+}
+
+func TestValidationErrorUnwrapsMergedErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	decode := errors.New("unexpected EOF")
+	fk := &ForeignKeyViolatedError{}
+
+	v := &ValidationError{}
+	v.Required("name", "")
+	v.Merge("avatar", Wrap(decode, "decoding avatar", "upload", 1234))
+	v.Merge("team", fk)
+
+	assert.ErrorIs(v, decode)
+	var found *ForeignKeyViolatedError
+	assert.ErrorAs(v, &found)
+
+	// it's still the validation error that decides the response
+	assert.Equal(http.StatusBadRequest, statusOf(v))
+	assert.Equal(GRPCInvalidArgument, grpcCodeOf(v))
+	assert.Equal(CodeValidationFailed, codeOf(v))
+
+	var logs bytes.Buffer
+	w := httptest.NewRecorder()
+	HandleError(w, nil, v, WithErrorLog(log.New(&logs, "", 0)))
+	assert.Contains(logs.String(), "decoding avatar upload=1234 at ")
+	assert.Contains(logs.String(), "validation_test.go")
+	assert.NotContains(w.Body.String(), "unexpected EOF")
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	v := &ValidationError{}
+	assert.Equal("the provided inputs are invalid", v.Error())
+
+	v.Required("foo", 0)
+	v.Check(false, "bar", "custom", 1, "must be even")
+	assert.Equal("the provided inputs are invalid for the following reasons: [foo is required, bar must be even]", v.Error())
+}
+
+func TestHandleErrorLegacyFields(t *testing.T) {
+	assert := assert.New(t)
+
+	v := &ValidationError{}
+	v.MaxLength("name", "toolong", 3)
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, fmt.Errorf("creating user: %w", v), WithLegacyFormat())
+
+	var resp ErrorResponse
+	assert.NoError(json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal([]FieldError{
+		{Field: "name", Rule: RuleMaxLength, Value: "toolong", Message: "must be at most 3 characters"},
+	}, resp.Fields)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// maxStackDepth limits how many frames Wrap captures.
+const maxStackDepth = 32
+
+// wrapError annotates an error with where it passed through
+// and what was going on at the time.
+type wrapError struct {
+	err error
+	op  string
+	kv  []any
+	pcs []uintptr
+}
+
+// Wrap annotates err with op, a description of what was being done,
+// key/value pairs describing what it was done to, and the stack at the
+// point Wrap is called:
+//
+//	return Wrap(err, "saving user", "entity", "user", "id", u.ID)
+//
+// The annotations only show up in logs, HandleError logs wrapped errors
+// whatever their status, and never shows the annotations to callers.
+// They can be printed with %+v, which shows the whole chain and the
+// stack. The wrapped error is still found by errors.Is and errors.As,
+// so a StatusAwareError keeps its status. Wrap returns nil if err is nil.
+func Wrap(err error, op string, kv ...any) error {
+	if err == nil {
+		return nil
+	}
+
+	pcs := make([]uintptr, maxStackDepth)
+	// skip runtime.Callers and Wrap itself
+	n := runtime.Callers(2, pcs)
+
+	return &wrapError{
+		err: err,
+		op:  op,
+		kv:  kv,
+		pcs: pcs[:n],
+	}
+}
+
+// Error implements error interface for wrapError.
+func (e *wrapError) Error() string {
+	return e.op + ": " + e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *wrapError) Unwrap() error {
+	return e.err
+}
+
+// Format implements fmt.Formatter for wrapError. %+v prints the message,
+// then each link in the chain with its context, then the deepest stack,
+// the same details HandleError logs. Other verbs print the message.
+func (e *wrapError) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		io.WriteString(s, e.Error())
+		for _, link := range errorChain(e) {
+			fmt.Fprintf(s, "\n\t%s", link)
+		}
+		if stack, ok := deepestStack(e); ok {
+			fmt.Fprintf(s, "\n%s", stack)
+		}
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	default:
+		io.WriteString(s, e.Error())
+	}
+}
+
+// Context returns the key/value pairs passed to Wrap.
+func (e *wrapError) Context() []any {
+	return e.kv
+}
+
+// StackTrace implements stackTracer for wrapError.
+func (e *wrapError) StackTrace() string {
+	var b strings.Builder
+
+	frames := runtime.CallersFrames(e.pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return b.String()
+}
+
+// describeLink implements linkDescriber for wrapError, so
+// the log shows where the wrap happened and its context.
+func (e *wrapError) describeLink() string {
+	var b strings.Builder
+	b.WriteString(e.op)
+
+	for i := 0; i < len(e.kv); i += 2 {
+		if i+1 == len(e.kv) {
+			// a key without a value, don't lose it
+			fmt.Fprintf(&b, " !BADKEY=%v", e.kv[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", e.kv[i], e.kv[i+1])
+	}
+
+	if len(e.pcs) > 0 {
+		frame, _ := runtime.CallersFrames(e.pcs[:1]).Next()
+		fmt.Fprintf(&b, " at %s:%d", frame.File, frame.Line)
+	}
+
+	return b.String()
+}
+
+// wrapped reports whether Wrap was used anywhere in err's chain.
+func wrapped(err error) bool {
+	var w *wrapError
+	return errors.As(err, &w)
+}
+
+// linkDescriber is implemented by errors that can describe their own
+// link in an error chain better than their type and message can.
+type linkDescriber interface {
+	describeLink() string
+}
+
+// deepestStack returns the stack captured closest to where err
+// originated, which is the most useful one to log.
+func deepestStack(err error) (string, bool) {
+	var stack string
+	var found bool
+
+	for err != nil {
+		var tracer stackTracer
+		if !errors.As(err, &tracer) {
+			break
+		}
+
+		stack, found = tracer.StackTrace(), true
+
+		// keep looking beneath the error we found
+		unwrapper, ok := tracer.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = unwrapper.Unwrap()
+	}
+
+	return stack, found
+}
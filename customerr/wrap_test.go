@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func findUser(id int) error {
+	return Wrap(&UniqueConstraintViolatedError{}, "inserting user", "entity", "user", "id", id)
+}
+
+func createUser(id int) error {
+	return Wrap(findUser(id), "creating user", "operation", "signup")
+}
+
+func TestWrapPreservesIsAndAs(t *testing.T) {
+	assert := assert.New(t)
+
+	err := createUser(42)
+
+	var unique *UniqueConstraintViolatedError
+	assert.ErrorAs(err, &unique)
+	var statusAware StatusAwareError
+	assert.ErrorAs(err, &statusAware)
+	assert.Equal(http.StatusConflict, statusOf(err))
+	assert.Equal(CodeUniqueViolation, codeOf(err))
+	assert.Equal("creating user: inserting user: cannot save record because another exists with the same ID", err.Error())
+}
+
+func TestWrapNil(t *testing.T) {
+	assert.NoError(t, Wrap(nil, "nothing to see"))
+}
+
+func TestWrapNeverInResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	HandleError(w, nil, createUser(42))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NotContains(t, w.Body.String(), "inserting user")
+	assert.NotContains(t, w.Body.String(), "signup")
+	assert.NotContains(t, w.Body.String(), "wrap_test.go")
+}
+
+func TestWrapChainInLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	err := Wrap(Wrap(errors.New("connection reset"), "querying users", "id", 7), "loading profile", "odd")
+
+	w := httptest.NewRecorder()
+	HandleError(w, nil, err, WithErrorLog(log.New(&logs, "", 0)))
+
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Contains(logs.String(), "loading profile !BADKEY=odd at ")
+	assert.Contains(logs.String(), "querying users id=7 at ")
+	assert.Contains(logs.String(), "wrap_test.go")
+	assert.Contains(logs.String(), "*errors.errorString: connection reset")
+	assert.NotContains(w.Body.String(), "connection reset")
+}
+
+func TestWrapClientErrorInLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	var logs bytes.Buffer
+	w := httptest.NewRecorder()
+	HandleError(w, nil, createUser(42), WithErrorLog(log.New(&logs, "", 0)))
+
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.Contains(logs.String(), "client error error=")
+	assert.Contains(logs.String(), "creating user operation=signup at ")
+	assert.Contains(logs.String(), "inserting user entity=user id=42 at ")
+	assert.Contains(logs.String(), "findUser")
+	assert.NotContains(logs.String(), "correlation_id")
+	assert.NotContains(w.Body.String(), "signup")
+
+	// unwrapped client errors are the caller's problem, not worth logging
+	logs.Reset()
+	HandleError(httptest.NewRecorder(), nil, &UniqueConstraintViolatedError{}, WithErrorLog(log.New(&logs, "", 0)))
+	assert.Empty(logs.String())
+}
+
+func TestWrapFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	err := createUser(42)
+
+	assert.Equal(err.Error(), fmt.Sprintf("%v", err))
+	assert.Equal(err.Error(), fmt.Sprintf("%s", err))
+	assert.Equal(fmt.Sprintf("%q", err.Error()), fmt.Sprintf("%q", err))
+
+	verbose := fmt.Sprintf("%+v", err)
+	assert.True(strings.HasPrefix(verbose, err.Error()+"\n"))
+	assert.Contains(verbose, "creating user operation=signup at ")
+	assert.Contains(verbose, "inserting user entity=user id=42 at ")
+	assert.Contains(verbose, "*main.UniqueConstraintViolatedError: ")
+	assert.Contains(verbose, "findUser")
+}
+
+func TestDeepestStack(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := findUser(1)
+	outer := Wrap(inner, "outer")
+
+	stack, ok := deepestStack(outer)
+	assert.True(ok)
+	assert.Equal(inner.(stackTracer).StackTrace(), stack)
+	assert.Contains(stack, "findUser")
+
+	_, ok = deepestStack(errors.New("plain"))
+	assert.False(ok)
+}
@@ -19,4 +19,10 @@ func main() {
 		Out: step3.AddFlush(os.Stdout),
 	}
 	log3.Info("hello world, again, again...")
+
+	log4 := step3.Logger{
+		Out:     step3.AddFlush(os.Stdout),
+		Encoder: step3.LogfmtEncoder{},
+	}
+	log4.With("step", 3).Warn("hello world, with levels", "again", 3)
 }
@@ -0,0 +1,291 @@
+// Flushing after every message keeps memory in check, but for
+// destinations like files each flush is a system call, and
+// it can end up costing more than the logging itself.
+// AsyncWriteFlusher sits between the logger and the real
+// destination, and batches messages up in the background.
+package step3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+)
+
+// ErrClosed is returned by writes to a closed AsyncWriteFlusher.
+var ErrClosed = errors.New("write flusher is closed")
+
+// defaultFlushInterval is how often queued writes are flushed,
+// unless WithFlushInterval says otherwise.
+const defaultFlushInterval = time.Second
+
+// FullPolicy is what AsyncWriteFlusher does with a
+// write when its buffer is full.
+type FullPolicy int
+
+const (
+	// DropWhenFull throws the write away, so logging
+	// never holds up the caller.
+	DropWhenFull FullPolicy = iota
+	// BlockWhenFull waits for room, so nothing is lost.
+	BlockWhenFull
+)
+
+type asyncConfig struct {
+	bufferSize    int
+	flushSize     int
+	flushInterval time.Duration
+	full          FullPolicy
+	clock         clock.Clock
+}
+
+// AsyncOption configures an AsyncWriteFlusher.
+type AsyncOption func(*asyncConfig)
+
+// WithBufferSize sets how many writes can be queued. Defaults to 1024.
+func WithBufferSize(n int) AsyncOption {
+	return func(c *asyncConfig) {
+		c.bufferSize = n
+	}
+}
+
+// WithFlushSize flushes as soon as n writes are queued. Defaults to 128.
+func WithFlushSize(n int) AsyncOption {
+	return func(c *asyncConfig) {
+		c.flushSize = n
+	}
+}
+
+// WithFlushInterval flushes whatever is queued every d,
+// so quiet periods don't hold messages back. Defaults to 1s,
+// which is also used if d isn't positive.
+func WithFlushInterval(d time.Duration) AsyncOption {
+	return func(c *asyncConfig) {
+		c.flushInterval = d
+	}
+}
+
+// WithFullPolicy sets what happens to writes when the buffer is full.
+// Defaults to DropWhenFull.
+func WithFullPolicy(p FullPolicy) AsyncOption {
+	return func(c *asyncConfig) {
+		c.full = p
+	}
+}
+
+// WithClock sets the clock for the flush interval, so tests can use a fake.
+func WithClock(c clock.Clock) AsyncOption {
+	return func(cfg *asyncConfig) {
+		cfg.clock = c
+	}
+}
+
+// AsyncWriteFlusher is a WriteFlusher that queues writes in a bounded
+// ring buffer, and writes them to another WriteFlusher in batches,
+// flushing it once per batch. Call Close when done with it, or
+// whatever is still queued is lost. It's safe for concurrent use.
+type AsyncWriteFlusher struct {
+	out WriteFlusher
+	cfg asyncConfig
+
+	mu      sync.Mutex
+	space   *sync.Cond
+	ring    [][]byte
+	head    int
+	count   int
+	dropped int
+	closed  bool
+	err     error
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// NewAsyncWriteFlusher creates an AsyncWriteFlusher writing to out,
+// and starts writing in the background.
+func NewAsyncWriteFlusher(out WriteFlusher, opts ...AsyncOption) *AsyncWriteFlusher {
+	cfg := asyncConfig{
+		bufferSize:    1024,
+		flushSize:     128,
+		flushInterval: defaultFlushInterval,
+		full:          DropWhenFull,
+		clock:         clock.New(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.bufferSize < 1 {
+		cfg.bufferSize = 1
+	}
+	if cfg.flushSize < 1 {
+		cfg.flushSize = 1
+	}
+	if cfg.flushSize > cfg.bufferSize {
+		// the buffer would never fill up enough to flush
+		cfg.flushSize = cfg.bufferSize
+	}
+	if cfg.flushInterval <= 0 {
+		// tickers need a positive interval
+		cfg.flushInterval = defaultFlushInterval
+	}
+
+	a := &AsyncWriteFlusher{
+		out:  out,
+		cfg:  cfg,
+		ring: make([][]byte, cfg.bufferSize),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	a.space = sync.NewCond(&a.mu)
+
+	go a.run(cfg.clock.NewTicker(cfg.flushInterval))
+
+	return a
+}
+
+// Write implements io.Writer for AsyncWriteFlusher. It queues a copy of
+// p, so callers can reuse it. When the buffer is full, p is dropped
+// or Write waits, depending on the FullPolicy. Either way Write only
+// fails once the AsyncWriteFlusher is closed.
+func (a *AsyncWriteFlusher) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for !a.closed && a.count == len(a.ring) {
+		if a.cfg.full == DropWhenFull {
+			a.dropped++
+			return len(p), nil
+		}
+		a.space.Wait()
+	}
+	if a.closed {
+		return 0, ErrClosed
+	}
+
+	a.ring[(a.head+a.count)%len(a.ring)] = append([]byte(nil), p...)
+	a.count++
+
+	if a.count >= a.cfg.flushSize {
+		a.signal()
+	}
+
+	return len(p), nil
+}
+
+// Flush implements WriteFlusher for AsyncWriteFlusher. Writes are
+// flushed in the background when there are enough of them, or the
+// interval passes, so Flush doesn't do anything itself. That lets
+// Logger keep calling it after every message without undoing the
+// batching. It returns how many writes are waiting.
+func (a *AsyncWriteFlusher) Flush() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.count
+}
+
+// Dropped returns how many writes have been thrown away
+// because the buffer was full.
+func (a *AsyncWriteFlusher) Dropped() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.dropped
+}
+
+// Close stops new writes, and waits until everything queued has been
+// written and flushed, or ctx is done. It returns ctx's error if it
+// gave up waiting, or the first error from the destination.
+func (a *AsyncWriteFlusher) Close(ctx context.Context) error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		// writers waiting for room would wait forever otherwise
+		a.space.Broadcast()
+		a.signal()
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return fmt.Errorf("closing before all writes were flushed: %w", ctx.Err())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.err
+}
+
+// signal wakes the background goroutine, if it isn't already awake.
+func (a *AsyncWriteFlusher) signal() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes batches until it's closed and there's nothing left.
+func (a *AsyncWriteFlusher) run(ticker clock.Ticker) {
+	defer close(a.done)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.wake:
+		case <-ticker.C():
+		}
+
+		batch, closed := a.take()
+		a.writeBatch(batch)
+
+		if closed {
+			return
+		}
+	}
+}
+
+// take empties the buffer. Once closed there's nothing else to come,
+// so the batch is the last one.
+func (a *AsyncWriteFlusher) take() ([][]byte, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	batch := make([][]byte, 0, a.count)
+	for ; a.count > 0; a.count-- {
+		batch = append(batch, a.ring[a.head])
+		a.ring[a.head] = nil
+		a.head = (a.head + 1) % len(a.ring)
+	}
+	a.space.Broadcast()
+
+	return batch, a.closed
+}
+
+// writeBatch writes the batch to the destination,
+// with a single flush at the end.
+func (a *AsyncWriteFlusher) writeBatch(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	for _, p := range batch {
+		if _, err := a.out.Write(p); err != nil {
+			a.setErr(err)
+		}
+	}
+	a.out.Flush()
+}
+
+func (a *AsyncWriteFlusher) setErr(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.err == nil {
+		a.err = err
+	}
+}
@@ -0,0 +1,239 @@
+// The async destination is just another WriteFlusher, so we can
+// test it with the same kind of fake. The difference is that
+// writes arrive in the background, so we have to wait for them.
+package step3_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/jmileson/scratch/mocking/step3"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncWriteFlusher records what's written and flushed, and can be
+// made to hold writes up. It's written from the background goroutine,
+// so it needs a lock.
+type syncWriteFlusher struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	flushes int
+
+	// if set, each write waits to receive from hold
+	hold    chan struct{}
+	writing chan struct{}
+}
+
+func (s *syncWriteFlusher) Write(p []byte) (int, error) {
+	if s.hold != nil {
+		s.writing <- struct{}{}
+		<-s.hold
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Write(p)
+}
+
+func (s *syncWriteFlusher) Flush() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flushes++
+	return 0
+}
+
+func (s *syncWriteFlusher) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.String()
+}
+
+func (s *syncWriteFlusher) Flushes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flushes
+}
+
+func newFakeClock() *clock.Fake {
+	return clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestAsyncFlushesOnSize(t *testing.T) {
+	assert := assert.New(t)
+
+	dest := &syncWriteFlusher{}
+	async := step3.NewAsyncWriteFlusher(dest, step3.WithFlushSize(3), step3.WithClock(newFakeClock()))
+	logger := step3.Logger{Out: async}
+
+	logger.Info("one")
+	logger.Info("two")
+	assert.Equal(2, async.Flush())
+	assert.Empty(dest.String())
+
+	logger.Info("three")
+	assert.Eventually(func() bool { return dest.String() == "one\ntwo\nthree\n" }, time.Second, time.Millisecond)
+	assert.Equal(1, dest.Flushes())
+
+	assert.NoError(async.Close(context.Background()))
+}
+
+func TestAsyncFlushesOnInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := newFakeClock()
+	dest := &syncWriteFlusher{}
+	async := step3.NewAsyncWriteFlusher(dest, step3.WithFlushInterval(time.Second), step3.WithClock(clk))
+
+	async.Write([]byte("quiet\n"))
+	clk.Advance(time.Second)
+
+	assert.Eventually(func() bool { return dest.String() == "quiet\n" }, time.Second, time.Millisecond)
+	assert.NoError(async.Close(context.Background()))
+}
+
+func TestAsyncDefaultsBadFlushInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	// the real clock's tickers panic without a positive interval
+	assert.NotPanics(func() {
+		async := step3.NewAsyncWriteFlusher(&syncWriteFlusher{}, step3.WithFlushInterval(0))
+		assert.NoError(async.Close(context.Background()))
+	})
+
+	clk := newFakeClock()
+	dest := &syncWriteFlusher{}
+	async := step3.NewAsyncWriteFlusher(dest, step3.WithFlushInterval(-time.Second), step3.WithClock(clk))
+
+	async.Write([]byte("quiet\n"))
+	clk.Advance(time.Second)
+
+	assert.Eventually(func() bool { return dest.String() == "quiet\n" }, time.Second, time.Millisecond)
+	assert.NoError(async.Close(context.Background()))
+}
+
+func TestAsyncDropsWhenFull(t *testing.T) {
+	assert := assert.New(t)
+
+	dest := &syncWriteFlusher{hold: make(chan struct{}), writing: make(chan struct{})}
+	async := step3.NewAsyncWriteFlusher(dest, step3.WithBufferSize(2), step3.WithFlushSize(1), step3.WithClock(newFakeClock()))
+
+	// the first is taken straight away, then held up in the destination
+	async.Write([]byte("1"))
+	<-dest.writing
+
+	async.Write([]byte("2"))
+	async.Write([]byte("3"))
+	n, err := async.Write([]byte("4"))
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Equal(1, async.Dropped())
+
+	go func() {
+		for range dest.writing {
+		}
+	}()
+	close(dest.hold)
+
+	assert.NoError(async.Close(context.Background()))
+	assert.Equal("123", dest.String())
+}
+
+func TestAsyncBlocksWhenFull(t *testing.T) {
+	assert := assert.New(t)
+
+	dest := &syncWriteFlusher{hold: make(chan struct{}), writing: make(chan struct{})}
+	async := step3.NewAsyncWriteFlusher(dest,
+		step3.WithBufferSize(2),
+		step3.WithFlushSize(1),
+		step3.WithFullPolicy(step3.BlockWhenFull),
+		step3.WithClock(newFakeClock()),
+	)
+
+	async.Write([]byte("1"))
+	<-dest.writing
+	async.Write([]byte("2"))
+	async.Write([]byte("3"))
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		async.Write([]byte("4"))
+	}()
+
+	select {
+	case <-written:
+		assert.Fail("write should wait for room")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	go func() {
+		for range dest.writing {
+		}
+	}()
+	close(dest.hold)
+	<-written
+
+	assert.NoError(async.Close(context.Background()))
+	assert.Equal("1234", dest.String())
+	assert.Equal(0, async.Dropped())
+}
+
+func TestAsyncCloseFlushesEverything(t *testing.T) {
+	assert := assert.New(t)
+
+	dest := &syncWriteFlusher{}
+	async := step3.NewAsyncWriteFlusher(dest, step3.WithClock(newFakeClock()))
+	logger := step3.Logger{Out: async}
+
+	for i := 0; i < 10; i++ {
+		logger.Info("sup")
+	}
+
+	assert.NoError(async.Close(context.Background()))
+	assert.Equal(40, len(dest.String()))
+	assert.Equal(1, dest.Flushes())
+
+	_, err := async.Write([]byte("too late"))
+	assert.ErrorIs(err, step3.ErrClosed)
+	// closing again is harmless
+	assert.NoError(async.Close(context.Background()))
+}
+
+func TestAsyncCloseDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	dest := &syncWriteFlusher{hold: make(chan struct{}), writing: make(chan struct{})}
+	async := step3.NewAsyncWriteFlusher(dest, step3.WithFlushSize(1), step3.WithClock(newFakeClock()))
+
+	async.Write([]byte("stuck"))
+	<-dest.writing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := async.Close(ctx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	close(dest.hold)
+}
+
+type failingWriteFlusher struct{}
+
+func (failingWriteFlusher) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+func (failingWriteFlusher) Flush() int                  { return 0 }
+
+func TestAsyncCloseReportsWriteErrors(t *testing.T) {
+	async := step3.NewAsyncWriteFlusher(failingWriteFlusher{}, step3.WithClock(newFakeClock()))
+	async.Write([]byte("lost"))
+
+	assert.EqualError(t, async.Close(context.Background()), "disk full")
+}
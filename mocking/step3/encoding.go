@@ -0,0 +1,230 @@
+// Once there's more to a message than the text, we need
+// to decide how to write it. Humans like logfmt, log
+// aggregators like JSON, so we support both, and the
+// logger doesn't need to know which it's using.
+package step3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Level is how important a message is.
+type Level int
+
+// The levels are spaced out, like log/slog's,
+// leaving room for more in between.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// String returns the level's name, in lower case.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// Field is a key/value pair describing a message.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// badKey is used for a value without a key, so it isn't lost.
+const badKey = "!BADKEY"
+
+// fieldsOf pairs up alternating keys and values.
+func fieldsOf(kv []any) []Field {
+	if len(kv) == 0 {
+		return nil
+	}
+
+	fields := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			fields = append(fields, Field{Key: badKey, Value: kv[i]})
+			break
+		}
+
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		fields = append(fields, Field{Key: key, Value: kv[i+1]})
+	}
+
+	return fields
+}
+
+// Entry is a message on its way to being written.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Encoder turns an entry into bytes to write. The result
+// should end with a newline.
+type Encoder interface {
+	Encode(e Entry) []byte
+}
+
+// plainEncoder is the original format, just the message, with
+// any fields after it. There's no time or level, the destination
+// is expected to add those if it wants them.
+type plainEncoder struct{}
+
+// Encode implements Encoder for plainEncoder.
+func (plainEncoder) Encode(e Entry) []byte {
+	var b bytes.Buffer
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		b.WriteByte(' ')
+		writeLogfmtPair(&b, f.Key, f.Value)
+	}
+	b.WriteByte('\n')
+
+	return b.Bytes()
+}
+
+// LogfmtEncoder writes key=value pairs, e.g.
+//
+//	time=2020-01-01T00:00:00Z level=info msg="user created" id=42
+type LogfmtEncoder struct{}
+
+// Encode implements Encoder for LogfmtEncoder.
+func (LogfmtEncoder) Encode(e Entry) []byte {
+	var b bytes.Buffer
+	if !e.Time.IsZero() {
+		writeLogfmtPair(&b, "time", e.Time)
+		b.WriteByte(' ')
+	}
+	writeLogfmtPair(&b, "level", e.Level)
+	b.WriteByte(' ')
+	writeLogfmtPair(&b, "msg", e.Message)
+	for _, f := range e.Fields {
+		b.WriteByte(' ')
+		writeLogfmtPair(&b, f.Key, f.Value)
+	}
+	b.WriteByte('\n')
+
+	return b.Bytes()
+}
+
+func writeLogfmtPair(b *bytes.Buffer, key string, value any) {
+	writeLogfmtValue(b, key)
+	b.WriteByte('=')
+	writeLogfmtValue(b, formatValue(value))
+}
+
+// writeLogfmtValue quotes s if it would be ambiguous unquoted.
+func writeLogfmtValue(b *bytes.Buffer, s string) {
+	if s != "" && !strings.ContainsAny(s, " =\"\\") && utf8.ValidString(s) && !hasControl(s) {
+		b.WriteString(s)
+		return
+	}
+
+	b.WriteString(strconv.Quote(s))
+}
+
+func hasControl(s string) bool {
+	for _, r := range s {
+		if r < ' ' || r == 0x7f {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatValue turns a field value into text for logfmt.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// JSONEncoder writes each message as a JSON object on its own line, e.g.
+//
+//	{"time":"2020-01-01T00:00:00Z","level":"info","msg":"user created","id":42}
+//
+// Fields come after the standard keys, in the order they were given.
+type JSONEncoder struct{}
+
+// Encode implements Encoder for JSONEncoder.
+func (JSONEncoder) Encode(e Entry) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	if !e.Time.IsZero() {
+		writeJSONPair(&b, "time", e.Time)
+		b.WriteByte(',')
+	}
+	writeJSONPair(&b, "level", e.Level.String())
+	b.WriteByte(',')
+	writeJSONPair(&b, "msg", e.Message)
+	for _, f := range e.Fields {
+		b.WriteByte(',')
+		writeJSONPair(&b, f.Key, f.Value)
+	}
+	b.WriteString("}\n")
+
+	return b.Bytes()
+}
+
+func writeJSONPair(b *bytes.Buffer, key string, value any) {
+	k, _ := json.Marshal(key)
+	b.Write(k)
+	b.WriteByte(':')
+	b.Write(jsonValue(value))
+}
+
+// jsonValue marshals v, falling back to its text if it can't be,
+// a message with an odd field is better than no message.
+func jsonValue(v any) []byte {
+	switch v := v.(type) {
+	case error:
+		// most errors marshal as {}, which isn't much use
+		s, _ := json.Marshal(v.Error())
+		return s
+	case json.Marshaler, time.Time:
+		// these know how to marshal themselves
+	case fmt.Stringer:
+		s, _ := json.Marshal(v.String())
+		return s
+	}
+
+	j, err := json.Marshal(v)
+	if err != nil {
+		j, _ = json.Marshal(fmt.Sprint(v))
+	}
+
+	return j
+}
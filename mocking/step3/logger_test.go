@@ -0,0 +1,129 @@
+// Levels, fields and encoders are all about what ends up
+// in the destination, so the tests look just like the
+// ones for Info: log something, then check the buffer.
+package step3_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/mocking/step3"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedNow makes timestamps predictable.
+func fixedNow() time.Time {
+	return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func TestLogLevels(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := bytes.Buffer{}
+	logger := step3.Logger{
+		Out:      step3.AddFlush(&buf),
+		MinLevel: step3.LevelWarn,
+	}
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	assert.Equal("warn\nerror\n", buf.String())
+	assert.False(logger.Enabled(step3.LevelInfo))
+	assert.True(logger.Enabled(step3.LevelError))
+}
+
+func TestLogDebugOffByDefault(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := step3.Logger{Out: step3.AddFlush(&buf)}
+
+	logger.Debug("hidden")
+	logger.Info("shown")
+
+	assert.Equal(t, "shown\n", buf.String())
+}
+
+func TestLogFields(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := step3.Logger{Out: step3.AddFlush(&buf)}
+
+	logger.Info("user created", "id", 42, "name", "Ada Lovelace", "odd")
+
+	assert.Equal(t, "user created id=42 name=\"Ada Lovelace\" !BADKEY=odd\n", buf.String())
+}
+
+func TestLogChildLoggers(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := bytes.Buffer{}
+	logger := step3.Logger{Out: step3.AddFlush(&buf)}
+
+	request := logger.With("request", "abc")
+	alice := request.With("user", "alice")
+	bob := request.With("user", "bob")
+
+	alice.Info("hello")
+	bob.Info("hello", "extra", true)
+	logger.Info("plain")
+
+	assert.Equal("hello request=abc user=alice\nhello request=abc user=bob extra=true\nplain\n", buf.String())
+}
+
+func TestLogfmtEncoder(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := step3.Logger{
+		Out:     step3.AddFlush(&buf),
+		Encoder: step3.LogfmtEncoder{},
+		Now:     fixedNow,
+	}
+
+	logger.With("component", "db").Error("query failed", "err", errors.New("connection reset"), "empty", "", "took", 1500*time.Millisecond)
+
+	assert.Equal(t, `time=2020-01-01T00:00:00Z level=error msg="query failed" component=db err="connection reset" empty="" took=1.5s`+"\n", buf.String())
+}
+
+func TestJSONEncoder(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := step3.Logger{
+		Out:     step3.AddFlush(&buf),
+		Encoder: step3.JSONEncoder{},
+		Now:     fixedNow,
+	}
+
+	logger.With("component", "db").Warn("slow query", "rows", 3, "err", errors.New("boom"), "took", 1500*time.Millisecond, "tags", []string{"a", "b"}, "ratio", math.Inf(1))
+
+	want := `{"time":"2020-01-01T00:00:00Z","level":"warn","msg":"slow query","component":"db","rows":3,"err":"boom","took":"1.5s","tags":["a","b"],"ratio":"+Inf"}` + "\n"
+	assert.Equal(t, want, buf.String())
+	// and it's valid, for anything reading it
+	assert.JSONEq(t, want, buf.String())
+}
+
+func TestLogFlushesEveryMessage(t *testing.T) {
+	buf := bytes.Buffer{}
+	flushes := 0
+	logger := step3.Logger{
+		Out: &fakeWriteFlusher{
+			Writer: &buf,
+			flush: func() int {
+				flushes++
+				return 0
+			},
+		},
+	}
+
+	logger.Info("one")
+	logger.Debug("dropped, so not flushed")
+	logger.Warn("two")
+
+	assert.Equal(t, 2, flushes)
+}
+
+func TestLevelString(t *testing.T) {
+	assert.Equal(t, "warn", step3.LevelWarn.String())
+	assert.Equal(t, "level(2)", step3.Level(2).String())
+}
@@ -11,8 +11,8 @@
 package step3
 
 import (
-	"fmt"
 	"io"
+	"time"
 )
 
 // Instead of using the io.Writer exclusively, we
@@ -25,13 +25,99 @@ type WriteFlusher interface {
 type Logger struct {
 	// And we update our struct accordingly
 	Out WriteFlusher
+
+	// Messages below MinLevel are dropped. The zero
+	// value is LevelInfo, so Debug is off by default.
+	MinLevel Level
+
+	// Encoder formats each message, see JSONEncoder and
+	// LogfmtEncoder. If it's nil, messages are written
+	// as they are, followed by any fields.
+	Encoder Encoder
+
+	// Now is used to timestamp messages, it's time.Now if nil.
+	// Tests can set it to get the same output every time.
+	Now func() time.Time
+
+	// fields are bound by With, and added to every message
+	fields []Field
 }
 
-func (l *Logger) Info(msg string) {
-	fmt.Fprintln(l.Out, msg)
+// Debug logs msg at LevelDebug, with key/value pairs
+// describing it, e.g. Debug("cache miss", "key", k).
+func (l *Logger) Debug(msg string, kv ...any) {
+	l.log(LevelDebug, msg, kv)
+}
+
+// Info logs msg at LevelInfo, with key/value pairs describing it.
+func (l *Logger) Info(msg string, kv ...any) {
+	l.log(LevelInfo, msg, kv)
+}
+
+// Warn logs msg at LevelWarn, with key/value pairs describing it.
+func (l *Logger) Warn(msg string, kv ...any) {
+	l.log(LevelWarn, msg, kv)
+}
+
+// Error logs msg at LevelError, with key/value pairs describing it.
+func (l *Logger) Error(msg string, kv ...any) {
+	l.log(LevelError, msg, kv)
+}
+
+// With returns a child logger that adds the key/value pairs to every
+// message, after any its parent adds. The parent is unchanged, and
+// both write to the same destination.
+func (l *Logger) With(kv ...any) *Logger {
+	child := *l
+	// copy, so siblings don't share a backing array
+	child.fields = append(append([]Field(nil), l.fields...), fieldsOf(kv)...)
+
+	return &child
+}
+
+// Enabled reports whether messages at level are logged, so
+// callers can skip working out fields that would be dropped.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.MinLevel
+}
+
+func (l *Logger) log(level Level, msg string, kv []any) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	entry := Entry{
+		Level:   level,
+		Message: msg,
+		Fields:  append(append([]Field(nil), l.fields...), fieldsOf(kv)...),
+	}
+	if l.Encoder != nil {
+		// the plain format leaves the time out, so only look it up when needed
+		entry.Time = l.now()
+	}
+
+	// one Write per message, so messages from
+	// different goroutines don't get mixed up
+	l.Out.Write(l.encoder().Encode(entry))
 	l.Out.Flush()
 }
 
+func (l *Logger) now() time.Time {
+	if l.Now == nil {
+		return time.Now()
+	}
+
+	return l.Now()
+}
+
+func (l *Logger) encoder() Encoder {
+	if l.Encoder == nil {
+		return plainEncoder{}
+	}
+
+	return l.Encoder
+}
+
 // We know that destinations like stdout and files handle
 // flushing on their own when Writing, so we can provide
 // a simple adaptor to support conversions for io.Writers
@@ -51,8 +51,13 @@ import (
 	"fmt"
 	"math/rand"
 	"time"
+
+	"github.com/jmileson/scratch/clock"
 )
 
+// clk is used for all the waiting, tests replace it with a fake
+var clk clock.Clock = clock.New()
+
 type Message struct {
 	ID        int
 	Timestamp time.Time
@@ -70,8 +75,9 @@ func ReceiveFromQueue(queue <-chan Message, eventIDs chan<- int) {
 func EventProcessor(eventIDs <-chan int) {
 	for eventID := range eventIDs {
 		fmt.Printf("processing event %d\n", eventID)
-		// simulate work
-		time.Sleep(time.Duration(rand.Intn(50)) * time.Millisecond)
+		// simulate work, always taking some time, so with a
+		// fake clock every event waits for it to move on
+		clk.Sleep(time.Duration(1+rand.Intn(50)) * time.Millisecond)
 	}
 }
 
@@ -101,5 +107,5 @@ func main() {
 	}
 
 	// simulate a server started on main thread
-	time.Sleep(3 * time.Second)
+	clk.Sleep(3 * time.Second)
 }
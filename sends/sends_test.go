@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiveFromQueue(t *testing.T) {
+	queue := make(chan Message, 3)
+	eventIDs := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		queue <- Message{i, time.Now()}
+	}
+	close(queue)
+
+	ReceiveFromQueue(queue, eventIDs)
+	close(eventIDs)
+
+	var ids []int
+	for id := range eventIDs {
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []int{0, 1, 2}, ids)
+}
+
+func TestEventProcessor(t *testing.T) {
+	fake := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	old := clk
+	clk = fake
+	t.Cleanup(func() { clk = old })
+
+	eventIDs := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		eventIDs <- i
+	}
+	close(eventIDs)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		EventProcessor(eventIDs)
+	}()
+
+	// each event sleeps for up to 50ms, one after the other
+	for i := 0; i < 3; i++ {
+		fake.BlockUntil(1)
+		fake.Advance(50 * time.Millisecond)
+	}
+
+	<-done
+	assert.Empty(t, eventIDs)
+}
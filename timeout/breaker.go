@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+)
+
+// errCircuitOpen is returned instead of calling a dependency
+// that's failing too often to be worth calling.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is where a Breaker is in its cycle.
+type BreakerState int
+
+const (
+	// BreakerClosed lets calls through, and watches how many fail.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects calls until the cooldown is over.
+	BreakerOpen
+	// BreakerHalfOpen lets a few trial calls through, to
+	// see whether the dependency has recovered.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "BreakerState(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// BreakerStateChange is sent to the OnStateChange listener.
+type BreakerStateChange struct {
+	From BreakerState
+	To   BreakerState
+	At   time.Time
+}
+
+type breakerConfig struct {
+	failureRate   float64
+	minRequests   int
+	window        time.Duration
+	cooldown      time.Duration
+	trialRequests int
+	isFailure     func(error) bool
+	onStateChange func(BreakerStateChange)
+	clock         clock.Clock
+}
+
+// BreakerOption configures a Breaker.
+type BreakerOption func(*breakerConfig)
+
+// WithFailureThreshold opens the breaker once rate (between 0 and 1) of
+// the calls in the window fail, as long as there were at least
+// minRequests of them. Defaults to half of at least 10 calls.
+func WithFailureThreshold(rate float64, minRequests int) BreakerOption {
+	return func(c *breakerConfig) {
+		c.failureRate = rate
+		c.minRequests = minRequests
+	}
+}
+
+// WithBreakerWindow sets how far back the failure rate looks. Defaults to 10s,
+// and it's never less than 10ns.
+func WithBreakerWindow(d time.Duration) BreakerOption {
+	return func(c *breakerConfig) {
+		c.window = d
+	}
+}
+
+// WithCooldown sets how long the breaker stays open before
+// trying the dependency again. Defaults to 5s.
+func WithCooldown(d time.Duration) BreakerOption {
+	return func(c *breakerConfig) {
+		c.cooldown = d
+	}
+}
+
+// WithTrialRequests sets how many calls are let through when half open.
+// All of them must succeed to close the breaker again. Defaults to 1,
+// and it's never less than 1.
+func WithTrialRequests(n int) BreakerOption {
+	return func(c *breakerConfig) {
+		c.trialRequests = n
+	}
+}
+
+// WithFailurePredicate decides which errors count as failures. By default
+// everything does, except context.Canceled, which means the caller gave
+// up rather than the dependency failing.
+func WithFailurePredicate(isFailure func(error) bool) BreakerOption {
+	return func(c *breakerConfig) {
+		c.isFailure = isFailure
+	}
+}
+
+// OnStateChange sets a function to call when the breaker changes state.
+// It's called synchronously, after the change, so it mustn't block.
+func OnStateChange(f func(BreakerStateChange)) BreakerOption {
+	return func(c *breakerConfig) {
+		c.onStateChange = f
+	}
+}
+
+// withBreakerClock sets the clock for the window and cooldown.
+func withBreakerClock(c clock.Clock) BreakerOption {
+	return func(cfg *breakerConfig) {
+		cfg.clock = c
+	}
+}
+
+// breakerBuckets is how many pieces the window is split into,
+// old calls drop out of the failure rate a bucket at a time.
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// Breaker stops calls to a dependency that keeps failing, so callers
+// fail fast instead of waiting out a timeout every time, and the
+// dependency gets a chance to recover. It's safe for concurrent use.
+type Breaker struct {
+	cfg breakerConfig
+
+	mu         sync.Mutex
+	state      BreakerState
+	generation uint64
+	openedAt   time.Time
+	buckets    [breakerBuckets]breakerBucket
+	trials     int
+	successes  int
+}
+
+// NewBreaker creates a closed Breaker.
+func NewBreaker(opts ...BreakerOption) *Breaker {
+	cfg := breakerConfig{
+		failureRate:   0.5,
+		minRequests:   10,
+		window:        10 * time.Second,
+		cooldown:      5 * time.Second,
+		trialRequests: 1,
+		isFailure: func(err error) bool {
+			return !errors.Is(err, context.Canceled)
+		},
+		clock: clock.New(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.window < breakerBuckets {
+		// each bucket needs to cover some time
+		cfg.window = breakerBuckets
+	}
+	if cfg.trialRequests < 1 {
+		// without a trial nothing could close it again
+		cfg.trialRequests = 1
+	}
+
+	return &Breaker{cfg: cfg}
+}
+
+// State returns the breaker's current state.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Do calls f if the breaker allows it, and records how it went.
+// If it doesn't, Do returns errCircuitOpen without calling f.
+// A panic in f counts as a failure, and carries on up the stack.
+func (b *Breaker) Do(ctx context.Context, f func(context.Context) error) error {
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	// record in a defer, or a panicking trial would keep its
+	// slot and leave the breaker half open for good
+	result := callFailed
+	defer func() {
+		b.record(generation, result)
+	}()
+
+	err = f(ctx)
+	switch {
+	case err == nil:
+		result = callSucceeded
+	case b.cfg.isFailure(err):
+		result = callFailed
+	default:
+		// e.g. the caller gave up, which says nothing
+		// about whether the dependency is healthy
+		result = callIgnored
+	}
+
+	return err
+}
+
+// Protect wraps a work-style function in b, so it can be
+// used anywhere the original was, including with Hedge.
+func Protect[T any](b *Breaker, f func(context.Context) (T, error)) func(context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		var v T
+		err := b.Do(ctx, func(ctx context.Context) error {
+			var err error
+			v, err = f(ctx)
+			return err
+		})
+
+		return v, err
+	}
+}
+
+// Handler wraps next in b. Responses with a 5xx status count as
+// failures, including those from a TimeoutHandler inside it, as do
+// panics. While the breaker is open, requests get a 503 with a
+// Retry-After header.
+func (b *Breaker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		generation, err := b.allow()
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(b.retryAfter()))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		// a panic counts as a failure, see Do
+		result := callFailed
+		defer func() {
+			b.record(generation, result)
+		}()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		if sw.status < http.StatusInternalServerError {
+			result = callSucceeded
+		}
+	})
+}
+
+// retryAfter returns the seconds left in the cooldown, rounded up.
+func (b *Breaker) retryAfter() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	left := b.cfg.cooldown - b.cfg.clock.Since(b.openedAt)
+	secs := int((left + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+
+	return secs
+}
+
+// allow reports whether a call can go ahead. The generation identifies
+// the state it was allowed in, so results from before a change of
+// state don't count towards the new one.
+func (b *Breaker) allow() (uint64, error) {
+	var change *BreakerStateChange
+	defer func() { b.notify(change) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.cfg.clock.Now()
+
+	if b.state == BreakerOpen {
+		if now.Sub(b.openedAt) < b.cfg.cooldown {
+			return 0, errCircuitOpen
+		}
+		change = b.setStateLocked(BreakerHalfOpen, now)
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.trials >= b.cfg.trialRequests {
+			return 0, errCircuitOpen
+		}
+		b.trials++
+	}
+
+	return b.generation, nil
+}
+
+// callResult is how a call went, as far as the breaker is concerned.
+type callResult int
+
+const (
+	callSucceeded callResult = iota
+	callFailed
+	// callIgnored is an error the failure predicate doesn't count,
+	// it's neither a success nor a failure.
+	callIgnored
+)
+
+// record counts the result of a call allowed in generation.
+func (b *Breaker) record(generation uint64, result callResult) {
+	var change *BreakerStateChange
+	defer func() { b.notify(change) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	now := b.cfg.clock.Now()
+
+	switch b.state {
+	case BreakerClosed:
+		if result == callIgnored {
+			return
+		}
+		b.countLocked(now, result == callFailed)
+		if b.trippedLocked(now) {
+			change = b.setStateLocked(BreakerOpen, now)
+		}
+	case BreakerHalfOpen:
+		switch result {
+		case callFailed:
+			change = b.setStateLocked(BreakerOpen, now)
+			return
+		case callIgnored:
+			// give the slot back for another trial to use
+			b.trials--
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.trialRequests {
+			change = b.setStateLocked(BreakerClosed, now)
+		}
+	}
+}
+
+// countLocked adds a call to the bucket for now.
+func (b *Breaker) countLocked(now time.Time, failed bool) {
+	width := b.cfg.window / breakerBuckets
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+
+	if !bucket.start.Equal(start) {
+		// left over from an earlier trip round the window
+		*bucket = breakerBucket{start: start}
+	}
+
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+}
+
+// trippedLocked reports whether enough calls
+// have failed in the window to open the breaker.
+func (b *Breaker) trippedLocked(now time.Time) bool {
+	requests, failures := 0, 0
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.cfg.window {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+
+	if requests == 0 || requests < b.cfg.minRequests {
+		return false
+	}
+
+	return float64(failures)/float64(requests) >= b.cfg.failureRate
+}
+
+// setStateLocked moves the breaker to state, starting a new generation.
+func (b *Breaker) setStateLocked(state BreakerState, now time.Time) *BreakerStateChange {
+	change := &BreakerStateChange{From: b.state, To: state, At: now}
+
+	b.state = state
+	b.generation++
+	b.trials = 0
+	b.successes = 0
+
+	switch state {
+	case BreakerOpen:
+		b.openedAt = now
+	case BreakerClosed:
+		// start afresh, the failures that opened it are history
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+
+	return change
+}
+
+// notify tells the listener about change, if there was one.
+func (b *Breaker) notify(change *BreakerStateChange) {
+	if change != nil && b.cfg.onStateChange != nil {
+		b.cfg.onStateChange(*change)
+	}
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter for statusWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
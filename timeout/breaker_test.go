@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func succeed(context.Context) error { return nil }
+func fail(context.Context) error    { return errFlaky }
+
+func newTestBreaker(opts ...BreakerOption) (*Breaker, *clock.Fake, *[]BreakerStateChange) {
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var changes []BreakerStateChange
+	opts = append([]BreakerOption{
+		withBreakerClock(clk),
+		WithFailureThreshold(0.5, 4),
+		WithBreakerWindow(10 * time.Second),
+		WithCooldown(5 * time.Second),
+		OnStateChange(func(c BreakerStateChange) { changes = append(changes, c) }),
+	}, opts...)
+
+	return NewBreaker(opts...), clk, &changes
+}
+
+func TestBreakerOpens(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _, changes := newTestBreaker()
+	ctx := context.Background()
+
+	// not enough calls to judge yet
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(b.Do(ctx, fail), errFlaky)
+	}
+	assert.Equal(BreakerClosed, b.State())
+
+	assert.ErrorIs(b.Do(ctx, fail), errFlaky)
+	assert.Equal(BreakerOpen, b.State())
+
+	called := false
+	err := b.Do(ctx, func(context.Context) error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(err, errCircuitOpen)
+	assert.False(called)
+
+	if assert.Len(*changes, 1) {
+		assert.Equal(BreakerClosed, (*changes)[0].From)
+		assert.Equal(BreakerOpen, (*changes)[0].To)
+	}
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _, _ := newTestBreaker()
+	ctx := context.Background()
+
+	// a third failing isn't enough
+	for i := 0; i < 6; i++ {
+		if i%3 == 2 {
+			b.Do(ctx, fail)
+		} else {
+			b.Do(ctx, succeed)
+		}
+	}
+	assert.Equal(BreakerClosed, b.State())
+
+	// cancelled calls aren't the dependency's fault
+	for i := 0; i < 10; i++ {
+		b.Do(ctx, func(context.Context) error { return context.Canceled })
+	}
+	assert.Equal(BreakerClosed, b.State())
+}
+
+func TestBreakerWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, _ := newTestBreaker()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		b.Do(ctx, fail)
+	}
+
+	// those failures have dropped out of the window by now
+	clk.Advance(11 * time.Second)
+	b.Do(ctx, fail)
+	for i := 0; i < 3; i++ {
+		b.Do(ctx, succeed)
+	}
+	assert.Equal(BreakerClosed, b.State())
+}
+
+func TestBreakerRecovers(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, changes := newTestBreaker(WithTrialRequests(2))
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		b.Do(ctx, fail)
+	}
+	assert.Equal(BreakerOpen, b.State())
+
+	clk.Advance(4 * time.Second)
+	assert.ErrorIs(b.Do(ctx, succeed), errCircuitOpen, "still cooling down")
+
+	clk.Advance(time.Second)
+
+	// two trial calls at once, a third is turned away
+	release := make(chan struct{})
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- b.Do(ctx, func(context.Context) error {
+				<-release
+				return nil
+			})
+		}()
+	}
+	assert.Eventually(func() bool { return b.State() == BreakerHalfOpen }, time.Second, time.Millisecond)
+	assert.Eventually(func() bool { return b.Do(ctx, succeed) == errCircuitOpen }, time.Second, time.Millisecond)
+
+	close(release)
+	assert.NoError(<-done)
+	assert.NoError(<-done)
+	assert.Equal(BreakerClosed, b.State())
+
+	var states []BreakerState
+	for _, c := range *changes {
+		states = append(states, c.To)
+	}
+	assert.Equal([]BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}, states)
+}
+
+func TestBreakerTrialFails(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, _ := newTestBreaker()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		b.Do(ctx, fail)
+	}
+	clk.Advance(5 * time.Second)
+
+	assert.ErrorIs(b.Do(ctx, fail), errFlaky)
+	assert.Equal(BreakerOpen, b.State())
+
+	// the cooldown starts again
+	clk.Advance(4 * time.Second)
+	assert.ErrorIs(b.Do(ctx, succeed), errCircuitOpen)
+}
+
+func TestBreakerIgnoresStaleResults(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, _ := newTestBreaker()
+	ctx := context.Background()
+
+	// a slow call started while closed
+	generation, err := b.allow()
+	assert.NoError(err)
+
+	for i := 0; i < 4; i++ {
+		b.Do(ctx, fail)
+	}
+	clk.Advance(5 * time.Second)
+	assert.NoError(b.Do(ctx, succeed))
+	assert.Equal(BreakerClosed, b.State())
+
+	// finishing late doesn't count against the new closed state
+	b.record(generation, callFailed)
+	assert.Equal(BreakerClosed, b.State())
+}
+
+func TestBreakerCancelledTrial(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, _ := newTestBreaker()
+	ctx := context.Background()
+	cancelled := func(context.Context) error { return context.Canceled }
+
+	for i := 0; i < 4; i++ {
+		b.Do(ctx, fail)
+	}
+	clk.Advance(5 * time.Second)
+
+	// the dependency never answered, so it's not a reason to close
+	assert.ErrorIs(b.Do(ctx, cancelled), context.Canceled)
+	assert.Equal(BreakerHalfOpen, b.State())
+
+	// and the trial slot is free for a call that does answer
+	assert.NoError(b.Do(ctx, succeed))
+	assert.Equal(BreakerClosed, b.State())
+
+	// once closed, cancelled calls don't water down the failure rate
+	for i := 0; i < 10; i++ {
+		b.Do(ctx, cancelled)
+	}
+	for i := 0; i < 4; i++ {
+		b.Do(ctx, fail)
+	}
+	assert.Equal(BreakerOpen, b.State())
+}
+
+func TestProtect(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _, _ := newTestBreaker(WithFailureThreshold(1, 1))
+	flaky := Protect(b, func(ctx context.Context) (bool, error) {
+		return false, errFlaky
+	})
+
+	_, err := flaky(context.Background())
+	assert.ErrorIs(err, errFlaky)
+	_, err = flaky(context.Background())
+	assert.ErrorIs(err, errCircuitOpen)
+}
+
+func TestBreakerHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, _ := newTestBreaker(WithFailureThreshold(1, 2))
+	h := b.Handler(TimeoutHandler(http.HandlerFunc(blockUntilDone), WithTimeout(time.Millisecond)))
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(http.StatusServiceUnavailable, serve(h, "/").Code)
+	}
+	assert.Equal(BreakerOpen, b.State())
+
+	clk.Advance(1500 * time.Millisecond)
+	w := serve(h, "/")
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	assert.Equal("4", w.Header().Get("Retry-After"))
+
+	ok := b.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	clk.Advance(4 * time.Second)
+	assert.Equal(http.StatusOK, serve(ok, "/").Code)
+	assert.Equal(BreakerClosed, b.State())
+}
+
+func TestBreakerStateString(t *testing.T) {
+	assert.Equal(t, "half-open", BreakerHalfOpen.String())
+	assert.Equal(t, "BreakerState(7)", BreakerState(7).String())
+}
+
+func TestBreakerPanickingTrial(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, _ := newTestBreaker()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		b.Do(ctx, fail)
+	}
+	clk.Advance(5 * time.Second)
+
+	assert.PanicsWithValue("boom", func() {
+		b.Do(ctx, func(context.Context) error { panic("boom") })
+	})
+	assert.Equal(BreakerOpen, b.State(), "the panic counts as a failed trial")
+
+	// and the trial slot is free again once the cooldown is over
+	clk.Advance(5 * time.Second)
+	assert.NoError(b.Do(ctx, succeed))
+	assert.Equal(BreakerClosed, b.State())
+}
+
+func TestBreakerHandlerPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _, _ := newTestBreaker(WithFailureThreshold(1, 2))
+	h := b.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	for i := 0; i < 2; i++ {
+		assert.Panics(func() { serve(h, "/") })
+	}
+	assert.Equal(BreakerOpen, b.State())
+}
+
+func TestBreakerNoTrials(t *testing.T) {
+	assert := assert.New(t)
+
+	b, clk, _ := newTestBreaker(WithTrialRequests(0))
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		b.Do(ctx, fail)
+	}
+	clk.Advance(5 * time.Second)
+
+	assert.NoError(b.Do(ctx, succeed))
+	assert.Equal(BreakerClosed, b.State())
+}
+
+func TestBreakerTinyWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	// a window shorter than its buckets used to divide by zero
+	b, _, _ := newTestBreaker(WithBreakerWindow(5))
+	ctx := context.Background()
+
+	assert.NotPanics(func() {
+		for i := 0; i < 4; i++ {
+			b.Do(ctx, fail)
+		}
+	})
+	assert.Equal(BreakerOpen, b.State())
+}
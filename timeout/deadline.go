@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DeadlineHeader carries the caller's deadline on outbound requests, as
+// an RFC 3339 timestamp. It's an absolute time, so the clocks on both
+// sides need to roughly agree, the safety margin covers the difference.
+const DeadlineHeader = "X-Request-Deadline"
+
+// defaultDeadlineMargin leaves time for the response to get back to the caller.
+const defaultDeadlineMargin = 50 * time.Millisecond
+
+// errNoTimeLeft is returned by DeadlineTransport when there's no point
+// making the request, because the caller will have given up before
+// the response arrives.
+var errNoTimeLeft = fmt.Errorf("not enough time left for request: %w", context.DeadlineExceeded)
+
+// DeadlineTransport passes the deadline on the request context to the
+// server, less a safety margin, and applies the same deadline to the
+// request itself. Requests without a deadline are sent unchanged.
+type DeadlineTransport struct {
+	// Base makes the requests, http.DefaultTransport if nil.
+	Base http.RoundTripper
+
+	// Margin is taken off the deadline to leave time for the response
+	// to get back, and for clock differences. Zero uses 50ms, use a
+	// negative value for no margin.
+	Margin time.Duration
+}
+
+func (t *DeadlineTransport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Base
+}
+
+func (t *DeadlineTransport) margin() time.Duration {
+	switch {
+	case t.Margin == 0:
+		return defaultDeadlineMargin
+	case t.Margin < 0:
+		return 0
+	default:
+		return t.Margin
+	}
+}
+
+// RoundTrip implements http.RoundTripper for DeadlineTransport.
+func (t *DeadlineTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	deadline, ok := r.Context().Deadline()
+	if !ok {
+		return t.base().RoundTrip(r)
+	}
+
+	deadline = deadline.Add(-t.margin())
+	if !time.Now().Before(deadline) {
+		if r.Body != nil {
+			// a RoundTripper must always close the body
+			r.Body.Close()
+		}
+		return nil, errNoTimeLeft
+	}
+
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+
+	// RoundTrippers mustn't change the request they're given
+	out := r.Clone(ctx)
+	out.Header.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+
+	resp, err := t.base().RoundTrip(out)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// the body is read after RoundTrip returns, so keep
+	// the context alive until the caller is done with it
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// cancelOnClose cancels a context when the body it wraps is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer for cancelOnClose.
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
+
+// DeadlineHandler applies the deadline in a request's DeadlineHeader to
+// its context, if it's earlier than any deadline it already has. Requests
+// whose deadline has passed get a 504 without reaching next, the caller
+// has already given up on them. Headers that can't be parsed are ignored.
+func DeadlineHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(DeadlineHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		deadline, err := time.Parse(time.RFC3339Nano, header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+			return
+		}
+
+		// WithDeadline keeps the parent's deadline if it's sooner
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
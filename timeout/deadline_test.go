@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTripFunc adapts a function to an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestDeadlinePropagates(t *testing.T) {
+	assert := assert.New(t)
+
+	var serverDeadline time.Time
+	server := httptest.NewServer(DeadlineHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		serverDeadline, ok = r.Context().Deadline()
+		assert.True(ok)
+	})))
+	defer server.Close()
+
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	client := &http.Client{Transport: &DeadlineTransport{Margin: time.Second}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NoError(err)
+
+	resp, err := client.Do(req)
+	if assert.NoError(err) {
+		resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+	}
+
+	// the header loses the monotonic clock reading, so allow a little slack
+	assert.WithinDuration(deadline.Add(-time.Second), serverDeadline, time.Microsecond)
+	assert.Empty(req.Header.Get(DeadlineHeader), "the caller's request is unchanged")
+}
+
+func TestDeadlineTransportWithoutDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	transport := &DeadlineTransport{Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		assert.Empty(r.Header.Get(DeadlineHeader))
+		_, ok := r.Context().Deadline()
+		assert.False(ok)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})}
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+}
+
+func TestDeadlineTransportNoTimeLeft(t *testing.T) {
+	assert := assert.New(t)
+
+	called := false
+	transport := &DeadlineTransport{
+		Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			called = true
+			return nil, nil
+		}),
+		Margin: time.Minute,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	assert.ErrorIs(err, errNoTimeLeft)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.False(called)
+}
+
+func TestDeadlineTransportCancelsOnClose(t *testing.T) {
+	assert := assert.New(t)
+
+	var outCtx context.Context
+	transport := &DeadlineTransport{Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		outCtx = r.Context()
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	assert.NoError(err)
+	assert.NoError(outCtx.Err(), "the body can still be read")
+
+	resp.Body.Close()
+	assert.ErrorIs(outCtx.Err(), context.Canceled)
+}
+
+func TestDeadlineHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var got time.Time
+	var hasDeadline bool
+	h := DeadlineHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, hasDeadline = r.Context().Deadline()
+	}))
+
+	serveWith := func(header string) *httptest.ResponseRecorder {
+		got, hasDeadline = time.Time{}, false
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set(DeadlineHeader, header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	deadline := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+	assert.Equal(http.StatusOK, serveWith(deadline.Format(time.RFC3339Nano)).Code)
+	assert.True(hasDeadline)
+	assert.True(deadline.Equal(got))
+
+	assert.Equal(http.StatusOK, serveWith("").Code)
+	assert.False(hasDeadline)
+
+	assert.Equal(http.StatusOK, serveWith("soon").Code)
+	assert.False(hasDeadline)
+
+	w := serveWith(time.Now().Add(-time.Second).Format(time.RFC3339Nano))
+	assert.Equal(http.StatusGatewayTimeout, w.Code)
+	assert.False(hasDeadline, "next isn't called")
+}
+
+func TestDeadlineShortensRouteTimeout(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(DeadlineHeader, time.Now().Add(10*time.Millisecond).Format(time.RFC3339Nano))
+
+	w := httptest.NewRecorder()
+	register().ServeHTTP(w, r)
+
+	// handleRequest takes 100ms, longer than the caller is willing to wait
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
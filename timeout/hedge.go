@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+)
+
+// HedgePolicy controls how Hedge makes extra attempts.
+type HedgePolicy struct {
+	// HedgeDelay is how long to wait for an attempt before starting
+	// another one alongside it. Zero means only retry after failures.
+	HedgeDelay time.Duration
+
+	// Latencies, if set, tracks how long successful attempts take, and
+	// once it has enough of them the hedge delay is their 95th
+	// percentile rather than HedgeDelay.
+	Latencies *LatencyWindow
+
+	// MaxAttempts limits the attempts made in all, including the first.
+	// Defaults to 3.
+	MaxAttempts int
+
+	// MaxInFlight limits how many attempts run at once. Defaults to 2.
+	MaxInFlight int
+
+	// BaseBackoff is the wait before retrying after the first failure,
+	// it doubles with each failure up to MaxBackoff. Waits are jittered
+	// so retries from many callers don't all arrive together.
+	// They default to 10ms and 1s.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Budget, if set, limits extra attempts across every call that shares it.
+	Budget *RetryBudget
+
+	// Retryable reports whether an attempt that failed with err is
+	// worth retrying. Defaults to retrying everything.
+	Retryable func(err error) bool
+
+	// Clock times the hedges and backoffs, it's the real clock if nil.
+	Clock clock.Clock
+}
+
+const (
+	defaultMaxAttempts = 3
+	defaultMaxInFlight = 2
+	defaultBaseBackoff = 10 * time.Millisecond
+	defaultMaxBackoff  = time.Second
+
+	// hedgeQuantile is the latency Latencies should hedge at.
+	hedgeQuantile = 0.95
+	// minLatencySamples is how many latencies are needed to trust the quantile.
+	minLatencySamples = 20
+)
+
+func (p HedgePolicy) withDefaults() HedgePolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.MaxInFlight <= 0 {
+		p.MaxInFlight = defaultMaxInFlight
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = defaultBaseBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	if p.Retryable == nil {
+		p.Retryable = func(error) bool { return true }
+	}
+	if p.Clock == nil {
+		p.Clock = clock.New()
+	}
+
+	return p
+}
+
+// hedgeDelay returns how long to wait before hedging, or zero not to.
+func (p HedgePolicy) hedgeDelay() time.Duration {
+	if p.Latencies != nil && p.Latencies.Len() >= minLatencySamples {
+		d, _ := p.Latencies.Quantile(hedgeQuantile)
+		return d
+	}
+
+	return p.HedgeDelay
+}
+
+// backoff returns the jittered wait before retrying after failures failures.
+func (p HedgePolicy) backoff(failures int) time.Duration {
+	d := p.BaseBackoff
+	for i := 1; i < failures && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	// wait at least half, so retries can't come straight back
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// Hedge calls f, starting another attempt if it's slow and retrying it
+// if it fails, within the limits of p. The first attempt to succeed wins,
+// and the rest are cancelled through their context. Attempts are made
+// in the background, so f must give up when its context is done:
+//
+//	ok, err := Hedge(ctx, policy, func(ctx context.Context) (bool, error) {
+//		return work(ctx, timeout)
+//	})
+//
+// If every attempt fails, Hedge returns the last error.
+func Hedge[T any](ctx context.Context, p HedgePolicy, f func(context.Context) (T, error)) (T, error) {
+	p = p.withDefaults()
+	var zero T
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		v    T
+		err  error
+		took time.Duration
+	}
+	// big enough that losing attempts never block
+	results := make(chan result, p.MaxAttempts)
+
+	started, inFlight := 0, 0
+	launch := func() {
+		started++
+		inFlight++
+		start := p.Clock.Now()
+		go func() {
+			v, err := f(ctx)
+			results <- result{v: v, err: err, took: p.Clock.Since(start)}
+		}()
+	}
+
+	// canLaunch reports whether there's room for another attempt,
+	// taking it from the budget if there is.
+	canLaunch := func() bool {
+		if started >= p.MaxAttempts || inFlight >= p.MaxInFlight {
+			return false
+		}
+		return p.Budget == nil || p.Budget.withdraw()
+	}
+
+	if p.Budget != nil {
+		p.Budget.deposit()
+	}
+	launch()
+
+	delay := p.hedgeDelay()
+	var hedgeTimer clock.Timer
+	var hedge <-chan time.Time
+	if delay > 0 {
+		hedgeTimer = p.Clock.NewTimer(delay)
+		defer hedgeTimer.Stop()
+		hedge = hedgeTimer.C()
+	}
+
+	var retry <-chan time.Time
+	var lastErr error
+	failures := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+
+		case res := <-results:
+			inFlight--
+			if res.err == nil {
+				if p.Latencies != nil {
+					p.Latencies.Record(res.took)
+				}
+				return res.v, nil
+			}
+
+			lastErr = res.err
+			if !p.Retryable(res.err) {
+				return zero, res.err
+			}
+			if started >= p.MaxAttempts {
+				if inFlight == 0 {
+					return zero, lastErr
+				}
+				// one of the others might still succeed
+				continue
+			}
+			if retry == nil {
+				failures++
+				retry = p.Clock.After(p.backoff(failures))
+			}
+
+		case <-retry:
+			retry = nil
+			if canLaunch() {
+				launch()
+			} else if inFlight == 0 {
+				return zero, fmt.Errorf("retry budget exhausted: %w", lastErr)
+			}
+
+		case <-hedge:
+			if canLaunch() {
+				launch()
+				hedgeTimer.Reset(delay)
+			} else {
+				// no room for now, failures are retried anyway
+				hedge = nil
+			}
+		}
+	}
+}
+
+// RetryBudget limits retries and hedges to a fraction of calls, so a
+// struggling dependency doesn't get several times its usual load. It's
+// safe for concurrent use, and is meant to be shared by all the calls
+// to a dependency.
+type RetryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+// NewRetryBudget creates a budget that allows ratio extra attempts per
+// call, e.g. 0.1 for 10%, saving up at most burst of them. It starts full.
+func NewRetryBudget(ratio float64, burst int) *RetryBudget {
+	return &RetryBudget{
+		ratio:  ratio,
+		max:    float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// deposit adds the allowance for a call.
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// withdraw takes an extra attempt from the budget, if there's one left.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// LatencyWindow keeps the most recent latencies, to work out quantiles.
+// It's safe for concurrent use.
+type LatencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// NewLatencyWindow creates a window of the last size latencies.
+// A size below 1 is treated as 1.
+func NewLatencyWindow(size int) *LatencyWindow {
+	if size < 1 {
+		size = 1
+	}
+
+	return &LatencyWindow{samples: make([]time.Duration, size)}
+}
+
+// Record adds a latency, replacing the oldest if the window is full.
+func (w *LatencyWindow) Record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// Len returns how many latencies are in the window.
+func (w *LatencyWindow) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lenLocked()
+}
+
+func (w *LatencyWindow) lenLocked() int {
+	if w.full {
+		return len(w.samples)
+	}
+
+	return w.next
+}
+
+// Quantile returns the latency that q (between 0 and 1) of the latencies
+// are at or below. It returns false if there are no latencies yet.
+func (w *LatencyWindow) Quantile(q float64) (time.Duration, bool) {
+	w.mu.Lock()
+	sorted := make([]time.Duration, w.lenLocked())
+	copy(sorted, w.samples)
+	w.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return 0, false
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	// nearest rank
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return sorted[i], true
+}
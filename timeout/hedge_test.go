@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+var errFlaky = errors.New("flaky")
+
+type hedgeResult struct {
+	v   int
+	err error
+}
+
+// startHedge runs Hedge in the background.
+func startHedge(ctx context.Context, p HedgePolicy, f func(context.Context) (int, error)) <-chan hedgeResult {
+	res := make(chan hedgeResult, 1)
+	go func() {
+		v, err := Hedge(ctx, p, f)
+		res <- hedgeResult{v, err}
+	}()
+
+	return res
+}
+
+func newHedgeClock() *clock.Fake {
+	return clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestHedgeFirstAttemptWins(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	v, err := Hedge(context.Background(), HedgePolicy{HedgeDelay: time.Hour}, func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 42, nil
+	})
+
+	assert.NoError(err)
+	assert.Equal(42, v)
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeSlowAttempt(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := newHedgeClock()
+	latencies := NewLatencyWindow(10)
+
+	var calls int32
+	loser := make(chan error, 1)
+	res := startHedge(context.Background(), HedgePolicy{HedgeDelay: 50 * time.Millisecond, Latencies: latencies, Clock: clk}, func(ctx context.Context) (int, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the slow one, it only stops when it loses
+			<-ctx.Done()
+			loser <- ctx.Err()
+			return 0, ctx.Err()
+		}
+		return 2, nil
+	})
+
+	clk.BlockUntil(1)
+	clk.Advance(50 * time.Millisecond)
+
+	r := <-res
+	assert.NoError(r.err)
+	assert.Equal(2, r.v)
+	assert.ErrorIs(<-loser, context.Canceled)
+	assert.Equal(1, latencies.Len())
+}
+
+func TestHedgeRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := newHedgeClock()
+
+	var calls int32
+	res := startHedge(context.Background(), HedgePolicy{MaxBackoff: 100 * time.Millisecond, Clock: clk}, func(ctx context.Context) (int, error) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return 0, errFlaky
+		}
+		return 3, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(100 * time.Millisecond)
+	}
+
+	r := <-res
+	assert.NoError(r.err)
+	assert.Equal(3, r.v)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeGivesUp(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := newHedgeClock()
+
+	var calls int32
+	res := startHedge(context.Background(), HedgePolicy{MaxAttempts: 2, MaxBackoff: 100 * time.Millisecond, Clock: clk}, func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, errFlaky
+	})
+
+	clk.BlockUntil(1)
+	clk.Advance(100 * time.Millisecond)
+
+	r := <-res
+	assert.ErrorIs(r.err, errFlaky)
+	assert.Equal(int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeNotRetryable(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	_, err := Hedge(context.Background(), HedgePolicy{Retryable: func(err error) bool {
+		return !errors.Is(err, errFlaky)
+	}}, func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, errFlaky
+	})
+
+	assert.ErrorIs(err, errFlaky)
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := newHedgeClock()
+	budget := NewRetryBudget(0.5, 1)
+
+	var calls int32
+	flaky := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, errFlaky
+	}
+	policy := HedgePolicy{MaxAttempts: 5, MaxBackoff: 100 * time.Millisecond, Budget: budget, Clock: clk}
+
+	// the budget starts with one retry
+	res := startHedge(context.Background(), policy, flaky)
+	for i := 0; i < 2; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(100 * time.Millisecond)
+	}
+
+	r := <-res
+	assert.ErrorIs(r.err, errFlaky)
+	assert.ErrorContains(r.err, "retry budget exhausted")
+	assert.Equal(int32(2), atomic.LoadInt32(&calls))
+
+	// then it takes two calls to earn another
+	budget.deposit()
+	assert.False(budget.withdraw())
+	budget.deposit()
+	assert.True(budget.withdraw())
+}
+
+func TestHedgeMaxInFlight(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := newHedgeClock()
+	release := make(chan struct{})
+
+	var calls int32
+	res := startHedge(context.Background(), HedgePolicy{HedgeDelay: 10 * time.Millisecond, MaxInFlight: 1, Clock: clk}, func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 1, nil
+	})
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	close(release)
+
+	r := <-res
+	assert.NoError(r.err)
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	res := startHedge(ctx, HedgePolicy{}, func(ctx context.Context) (int, error) {
+		cancel()
+		<-ctx.Done()
+		return 0, errFlaky
+	})
+
+	assert.ErrorIs(t, (<-res).err, context.Canceled)
+}
+
+func TestHedgeWork(t *testing.T) {
+	ok, err := Hedge(context.Background(), HedgePolicy{HedgeDelay: time.Second}, func(ctx context.Context) (bool, error) {
+		return work(ctx, 2*time.Second)
+	})
+
+	assert.True(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestLatencyWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	w := NewLatencyWindow(100)
+	_, ok := w.Quantile(0.95)
+	assert.False(ok)
+
+	for i := 1; i <= 150; i++ {
+		w.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	// only the last 100 are kept, 51ms to 150ms
+	assert.Equal(100, w.Len())
+	p95, ok := w.Quantile(0.95)
+	assert.True(ok)
+	assert.Equal(145*time.Millisecond, p95)
+	p0, _ := w.Quantile(0)
+	assert.Equal(51*time.Millisecond, p0)
+}
+
+func TestLatencyWindowTooSmall(t *testing.T) {
+	assert := assert.New(t)
+
+	w := NewLatencyWindow(0)
+	assert.NotPanics(func() {
+		w.Record(time.Millisecond)
+		w.Record(2 * time.Millisecond)
+	})
+
+	assert.Equal(1, w.Len())
+	d, ok := w.Quantile(0.5)
+	assert.True(ok)
+	assert.Equal(2*time.Millisecond, d)
+}
@@ -1,48 +1,304 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
+	"sync"
 	"time"
+
+	"github.com/jmileson/scratch/clock"
 )
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
-	ch := make(chan bool, 1)
-	simulateWork(1*time.Second, ch)
+	handleWork(w, r, clock.New(), 1*time.Second)
+}
+
+// handleWork is handleRequest, with the work timed by clk.
+func handleWork(w http.ResponseWriter, r *http.Request, clk clock.Clock, timeout time.Duration) {
+	ok, err := workWithClock(r.Context(), clk, timeout)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// the request timed out or the client went away,
+			// TimeoutHandler deals with both
+			return
+		}
+
+		// the work ran out of its own time, while the
+		// request still had some
+		http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+		return
+	}
 
 	status := http.StatusOK
 
 	w.WriteHeader(status)
 
-	ok := <-ch
 	json.NewEncoder(w).Encode(map[string]bool{"result": ok})
 }
 
-func TimeoutHandler(next http.Handler) http.Handler {
+const defaultTimeout = 2 * time.Second
+
+type timeoutConfig struct {
+	clock         clock.Clock
+	timeout       time.Duration
+	headerTimeout time.Duration
+	status        int
+	contentType   string
+	body          []byte
+}
+
+// TimeoutOption configures TimeoutHandler.
+type TimeoutOption func(*timeoutConfig)
+
+// WithTimeout sets how long the handler has to finish its response.
+func WithTimeout(d time.Duration) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.timeout = d
+	}
+}
+
+// WithHeaderTimeout sets how long the handler has to start its response,
+// by calling WriteHeader or Write. Once it has, it has until the overall
+// timeout to finish. It's ignored unless it's shorter than the timeout.
+func WithHeaderTimeout(d time.Duration) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.headerTimeout = d
+	}
+}
+
+// WithTimeoutStatus sets the status written when the handler times out,
+// usually 503 Service Unavailable or 504 Gateway Timeout.
+func WithTimeoutStatus(status int) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.status = status
+	}
+}
+
+// WithTimeoutBody sets the body written when the handler times out.
+func WithTimeoutBody(contentType string, body []byte) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.contentType = contentType
+		c.body = body
+	}
+}
+
+// withClock sets the clock used for the header timeout. The overall
+// timeout is a context deadline, so it always uses the real clock.
+func withClock(c clock.Clock) TimeoutOption {
+	return func(cfg *timeoutConfig) {
+		cfg.clock = c
+	}
+}
+
+func newTimeoutConfig(opts []TimeoutOption) timeoutConfig {
+	cfg := timeoutConfig{
+		clock:       clock.New(),
+		timeout:     defaultTimeout,
+		status:      http.StatusServiceUnavailable,
+		contentType: "text/plain; charset=utf-8",
+		body:        []byte(http.StatusText(http.StatusServiceUnavailable) + "\n"),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// TimeoutHandler runs next with a deadline on the request context. The
+// response is buffered, and only sent if next finishes in time, otherwise
+// the client gets the timeout response and anything next writes later is
+// thrown away. Handlers should watch r.Context() so they stop working
+// once nobody is waiting for them.
+func TimeoutHandler(next http.Handler, opts ...TimeoutOption) http.Handler {
+	return timeoutHandler(next, newTimeoutConfig(opts))
+}
+
+func timeoutHandler(next http.Handler, cfg timeoutConfig) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		wrap := func(ch chan<- bool) {
-			next.ServeHTTP(w, r)
-			ch <- true
-		}
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{ctx: ctx, header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
 
-		ch := make(chan bool, 1)
-		go wrap(ch)
+		go func() {
+			defer func() {
+				if v := recover(); v != nil {
+					panicked <- v
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		var headerTimeout <-chan time.Time
+		if cfg.hasHeaderTimeout() {
+			t := cfg.clock.NewTimer(cfg.headerTimeout)
+			defer t.Stop()
+			headerTimeout = t.C()
+		}
 
-		select {
-		case <-time.After(2 * time.Second):
-			// write error response
-			// log?
-		case <-ch:
-			// nothing to do
+		for {
+			select {
+			case v := <-panicked:
+				// let the server deal with it, as if there was no timeout handler
+				panic(v)
+			case <-done:
+				tw.flushTo(w)
+				return
+			case <-headerTimeout:
+				if tw.expireIfNotStarted() {
+					// returning cancels the context, so the handler stops too
+					cfg.writeTimeout(w)
+					return
+				}
+				headerTimeout = nil
+			case <-ctx.Done():
+				tw.expire(ctx.Err())
+				if ctx.Err() == context.DeadlineExceeded {
+					cfg.writeTimeout(w)
+				}
+				// otherwise the client went away, there's no one to respond to
+				return
+			}
 		}
 	})
 }
 
+// hasHeaderTimeout reports whether the header timeout is worth enforcing.
+func (c timeoutConfig) hasHeaderTimeout() bool {
+	return c.headerTimeout > 0 && c.headerTimeout < c.timeout
+}
+
+// writeTimeout writes the timeout response.
+func (c timeoutConfig) writeTimeout(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", c.contentType)
+	w.WriteHeader(c.status)
+	w.Write(c.body)
+}
+
+// timeoutWriter buffers a response until the handler finishes,
+// and rejects writes once the request has timed out.
+type timeoutWriter struct {
+	ctx         context.Context
+	mu          sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+	err         error
+}
+
+// Header implements http.ResponseWriter for timeoutWriter.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// Write implements http.ResponseWriter for timeoutWriter.
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if err := tw.errLocked(); err != nil {
+		return 0, err
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+
+	return tw.body.Write(p)
+}
+
+// WriteHeader implements http.ResponseWriter for timeoutWriter.
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.errLocked() != nil || tw.wroteHeader {
+		return
+	}
+
+	tw.writeHeaderLocked(status)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+	tw.wroteHeader = true
+	tw.status = status
+}
+
+// errLocked returns the error for writes that are too late. The handler
+// can see its context is done before TimeoutHandler does, so that's
+// checked as well as whether tw has been expired.
+func (tw *timeoutWriter) errLocked() error {
+	if tw.err == nil && tw.ctx.Err() != nil {
+		tw.expireLocked(tw.ctx.Err())
+	}
+
+	return tw.err
+}
+
+// expire stops the handler writing anything else. Late writes get
+// http.ErrHandlerTimeout, or the context error if the client went away.
+func (tw *timeoutWriter) expire(err error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.expireLocked(err)
+}
+
+func (tw *timeoutWriter) expireLocked(err error) {
+	if err == context.DeadlineExceeded {
+		err = http.ErrHandlerTimeout
+	}
+	tw.err = err
+}
+
+// expireIfNotStarted expires tw if the handler hasn't started
+// its response, and reports whether it did.
+func (tw *timeoutWriter) expireIfNotStarted() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.wroteHeader {
+		return false
+	}
+
+	tw.err = http.ErrHandlerTimeout
+	return true
+}
+
+// flushTo copies the buffered response to w.
+func (tw *timeoutWriter) flushTo(w http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	dst := w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+
+	if !tw.wroteHeader {
+		tw.status = http.StatusOK
+	}
+	w.WriteHeader(tw.status)
+	w.Write(tw.body.Bytes())
+}
+
 func register() http.Handler {
-	mux := http.NewServeMux()
+	router := NewRouter()
+
+	router.Handle("/", http.HandlerFunc(handleRequest))
+	router.Handle("/debug/timeouts", router.DebugHandler(), WithTimeout(time.Second))
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {}, WithTimeout(time.Second))
 
-	mux.HandleFunc("/", func(http.ResponseWriter, *http.Request) {}))
-	mux.Handle("/", TimeoutHandler(http.HandlerFunc(handleRequest)))
+	// shed load before it turns into timeouts
+	limiter := NewConcurrencyLimiter(WithLatencyTarget(defaultTimeout / 2))
 
-	return mux
+	// callers can shorten the timeouts with their own deadline
+	return DeadlineHandler(limiter.Handler(router))
 }
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/jmileson/scratch/clock"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,3 +28,117 @@ func TestHandle(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal("{\"result\":true}\n", string(b))
 }
+
+func TestHandleWorkTimesOut(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleWork(w, httptest.NewRequest(http.MethodGet, "/", nil), clk, 50*time.Millisecond)
+	}()
+
+	// waiting on both the worker and the timeout
+	clk.BlockUntil(2)
+	clk.Advance(50 * time.Millisecond)
+	<-done
+
+	// not an empty 200, the request itself was still alive
+	assert.Equal(http.StatusGatewayTimeout, w.Code)
+}
+
+func TestTimeoutHandlerPassesThrough(t *testing.T) {
+	assert := assert.New(t)
+
+	h := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Context().Deadline()
+		assert.True(ok)
+
+		w.Header().Set("X-Answer", "42")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Equal("42", w.Header().Get("X-Answer"))
+	assert.Equal("created", w.Body.String())
+}
+
+func TestTimeoutHandlerTimesOut(t *testing.T) {
+	assert := assert.New(t)
+
+	lateWrite := make(chan error, 1)
+	h := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Partial", "yes")
+		w.Write([]byte("partial"))
+
+		<-r.Context().Done()
+
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	}), WithTimeout(10*time.Millisecond))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	assert.Equal("Service Unavailable\n", w.Body.String())
+	assert.Empty(w.Header().Get("X-Partial"))
+	assert.ErrorIs(<-lateWrite, http.ErrHandlerTimeout)
+	assert.Equal("Service Unavailable\n", w.Body.String())
+}
+
+func TestTimeoutHandlerCustomResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	h := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}),
+		WithTimeout(10*time.Millisecond),
+		WithTimeoutStatus(http.StatusGatewayTimeout),
+		WithTimeoutBody("application/json", []byte(`{"error":"timeout"}`)),
+	)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(http.StatusGatewayTimeout, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Equal(`{"error":"timeout"}`, w.Body.String())
+}
+
+func TestTimeoutHandlerClientGone(t *testing.T) {
+	assert := assert.New(t)
+
+	handlerErr := make(chan error, 1)
+	h := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		handlerErr <- r.Context().Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	assert.ErrorIs(<-handlerErr, context.Canceled)
+	// nothing was written, so the recorder has its default
+	assert.False(w.Flushed)
+	assert.Empty(w.Body.String())
+}
+
+func TestTimeoutHandlerPanics(t *testing.T) {
+	h := TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	assert.PanicsWithValue(t, "boom", func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
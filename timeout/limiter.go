@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+)
+
+// Priority decides which requests are shed first when overloaded.
+type Priority int
+
+const (
+	// PriorityLow requests are shed before the limit is reached,
+	// so there's room left for normal ones.
+	PriorityLow Priority = iota - 1
+	// PriorityNormal requests are shed once the limit is reached.
+	PriorityNormal
+	// PriorityCritical requests are never shed, and don't count
+	// towards the limit. It's meant for health checks, which need
+	// to answer most of all when the server is busy.
+	PriorityCritical
+)
+
+// healthCheckPaths get PriorityCritical by default.
+var healthCheckPaths = map[string]bool{
+	"/healthz": true,
+	"/livez":   true,
+	"/readyz":  true,
+}
+
+// defaultPriority makes health checks critical and everything else normal.
+func defaultPriority(r *http.Request) Priority {
+	if healthCheckPaths[r.URL.Path] {
+		return PriorityCritical
+	}
+
+	return PriorityNormal
+}
+
+type limiterConfig struct {
+	initialLimit  int
+	minLimit      int
+	maxLimit      int
+	latencyTarget time.Duration
+	backoff       float64
+	lowShare      float64
+	priority      func(*http.Request) Priority
+	clock         clock.Clock
+}
+
+// LimiterOption configures a ConcurrencyLimiter.
+type LimiterOption func(*limiterConfig)
+
+// WithInitialLimit sets the limit to start from. Defaults to 20.
+func WithInitialLimit(n int) LimiterOption {
+	return func(c *limiterConfig) {
+		c.initialLimit = n
+	}
+}
+
+// WithLimitBounds keeps the limit between min and max. Defaults to 1 and 1000.
+func WithLimitBounds(min, max int) LimiterOption {
+	return func(c *limiterConfig) {
+		c.minLimit = min
+		c.maxLimit = max
+	}
+}
+
+// WithLatencyTarget sets the latency above which a request counts as a
+// sign of overload. It should be comfortably above the usual latency,
+// and below the timeout. Defaults to 1s.
+func WithLatencyTarget(d time.Duration) LimiterOption {
+	return func(c *limiterConfig) {
+		c.latencyTarget = d
+	}
+}
+
+// WithPriority sets how requests are prioritised. By default /healthz,
+// /livez and /readyz are critical, and everything else is normal.
+func WithPriority(f func(*http.Request) Priority) LimiterOption {
+	return func(c *limiterConfig) {
+		c.priority = f
+	}
+}
+
+// withLimiterClock sets the clock used to time requests.
+func withLimiterClock(c clock.Clock) LimiterOption {
+	return func(cfg *limiterConfig) {
+		cfg.clock = c
+	}
+}
+
+// ConcurrencyLimiter sheds load by limiting how many requests are handled
+// at once. The limit adapts to how the server copes, with additive
+// increase and multiplicative decrease (AIMD): it creeps up while
+// requests are quick and the limit is being used, and drops sharply
+// when requests are slow, or time out. Requests over the limit are
+// rejected straight away, rather than queueing and timing out later.
+// It's safe for concurrent use.
+type ConcurrencyLimiter struct {
+	cfg limiterConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter.
+func NewConcurrencyLimiter(opts ...LimiterOption) *ConcurrencyLimiter {
+	cfg := limiterConfig{
+		initialLimit:  20,
+		minLimit:      1,
+		maxLimit:      1000,
+		latencyTarget: time.Second,
+		backoff:       0.9,
+		lowShare:      0.75,
+		priority:      defaultPriority,
+		clock:         clock.New(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	l := &ConcurrencyLimiter{cfg: cfg}
+	l.limit = l.clamp(float64(cfg.initialLimit))
+
+	return l
+}
+
+// Limit returns the current limit.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// InFlight returns how many requests are counting towards the limit.
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inFlight
+}
+
+// Handler wraps next with the limit. Rejected requests get
+// a 503 with a Retry-After header.
+func (l *ConcurrencyLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := l.cfg.priority(r)
+		if priority >= PriorityCritical {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !l.acquire(priority) {
+			// a second is long enough for things to change, but
+			// short enough that clients don't wait needlessly
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		// release in a defer, so a panic doesn't keep its place
+		// for good, it counts as failed like an overload would
+		start := l.cfg.clock.Now()
+		failed := true
+		defer func() {
+			l.release(l.cfg.clock.Since(start), failed)
+		}()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		failed = overloaded(sw.status)
+	})
+}
+
+// overloaded reports whether a status means the server couldn't cope.
+func overloaded(status int) bool {
+	return status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// acquire takes a place under the limit, if there's one for priority.
+func (l *ConcurrencyLimiter) acquire(priority Priority) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.limit
+	if priority < PriorityNormal {
+		limit *= l.cfg.lowShare
+	}
+
+	if float64(l.inFlight) >= limit {
+		return false
+	}
+
+	l.inFlight++
+	return true
+}
+
+// release gives up a place, and adjusts the limit
+// based on how the request went.
+func (l *ConcurrencyLimiter) release(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// judge utilisation while this request still counts
+	busy := float64(l.inFlight) >= l.limit/2
+	l.inFlight--
+
+	switch {
+	case failed || latency > l.cfg.latencyTarget:
+		l.limit = l.clamp(l.limit * l.cfg.backoff)
+	case busy:
+		// about one more for every limit's worth of requests, so
+		// the limit grows by one each round trip, like TCP
+		l.limit = l.clamp(l.limit + 1/l.limit)
+	}
+}
+
+func (l *ConcurrencyLimiter) clamp(limit float64) float64 {
+	if limit < float64(l.cfg.minLimit) {
+		return float64(l.cfg.minLimit)
+	}
+	if limit > float64(l.cfg.maxLimit) {
+		return float64(l.cfg.maxLimit)
+	}
+
+	return limit
+}
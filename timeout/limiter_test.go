@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler holds requests until released, to fill up the limit.
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.started <- struct{}{}
+	<-h.release
+}
+
+// fill starts n requests through h, and waits until they're all in.
+func fill(t *testing.T, h http.Handler, blocking *blockingHandler, n int) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusOK, serve(h, "/work").Code)
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-blocking.started
+	}
+
+	return &wg
+}
+
+func TestLimiterSheds(t *testing.T) {
+	assert := assert.New(t)
+
+	blocking := newBlockingHandler()
+	limiter := NewConcurrencyLimiter(WithInitialLimit(2))
+	h := limiter.Handler(blocking)
+
+	wg := fill(t, h, blocking, 2)
+	assert.Equal(2, limiter.InFlight())
+
+	w := serve(h, "/work")
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	assert.Equal("1", w.Header().Get("Retry-After"))
+
+	// health checks get through regardless, and don't use up the limit
+	health := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(http.StatusOK, serve(health, "/healthz").Code)
+	assert.Equal(2, limiter.InFlight())
+
+	close(blocking.release)
+	wg.Wait()
+	assert.Equal(0, limiter.InFlight())
+}
+
+func TestLimiterLowPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	blocking := newBlockingHandler()
+	limiter := NewConcurrencyLimiter(WithInitialLimit(4), WithPriority(func(r *http.Request) Priority {
+		if r.URL.Path == "/batch" {
+			return PriorityLow
+		}
+		return PriorityNormal
+	}))
+	h := limiter.Handler(blocking)
+
+	wg := fill(t, h, blocking, 3)
+
+	// low priority requests only get three quarters of the limit
+	assert.Equal(http.StatusServiceUnavailable, serve(h, "/batch").Code)
+
+	wg2 := fill(t, h, blocking, 1)
+	close(blocking.release)
+	wg.Wait()
+	wg2.Wait()
+}
+
+func TestLimiterAdapts(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	var latency time.Duration
+	status := http.StatusOK
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clk.Advance(latency)
+		w.WriteHeader(status)
+	})
+
+	limiter := NewConcurrencyLimiter(
+		WithInitialLimit(1),
+		WithLimitBounds(1, 2),
+		WithLatencyTarget(100*time.Millisecond),
+		withLimiterClock(clk),
+	)
+	limited := limiter.Handler(h)
+
+	// quick requests that use the limit raise it, up to the maximum
+	for i := 0; i < 20; i++ {
+		serve(limited, "/")
+	}
+	assert.Equal(2, limiter.Limit())
+
+	// slow ones cut it
+	latency = time.Second
+	serve(limited, "/")
+	assert.Equal(1, limiter.Limit())
+
+	// as do timeouts, however quick, down to the minimum
+	latency = 0
+	status = http.StatusGatewayTimeout
+	for i := 0; i < 20; i++ {
+		serve(limited, "/")
+	}
+	assert.Equal(1, limiter.Limit())
+
+	// and it recovers once they stop
+	status = http.StatusOK
+	for i := 0; i < 20; i++ {
+		serve(limited, "/")
+	}
+	assert.Equal(2, limiter.Limit())
+}
+
+func TestLimiterPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	limiter := NewConcurrencyLimiter(WithInitialLimit(2))
+	h := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	for i := 0; i < 2; i++ {
+		assert.Panics(func() { serve(h, "/work") })
+	}
+	assert.Equal(0, limiter.InFlight(), "panics give their places back")
+
+	ok := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(http.StatusOK, serve(ok, "/work").Code)
+}
+
+func TestRegisterHealthCheck(t *testing.T) {
+	w := httptest.NewRecorder()
+	register().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -1,30 +1,53 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"time"
+
+	"github.com/jmileson/scratch/clock"
 )
 
 var (
-	errTimeout = errors.New("timeout")
+	// errTimeout is returned when work runs out of time. It wraps
+	// context.DeadlineExceeded, so callers can check for either.
+	errTimeout = fmt.Errorf("timeout: %w", context.DeadlineExceeded)
 )
 
-func simulateWork(timeout time.Duration, ch chan<- bool) {
-	time.Sleep(100 * time.Millisecond)
+func simulateWork(ctx context.Context, clk clock.Clock, timeout time.Duration, ch chan<- bool) {
+	select {
+	case <-ctx.Done():
+		// nobody is waiting for the result
+		return
+	case <-clk.After(100 * time.Millisecond):
+	}
 
 	ch <- (timeout % 2) == 0
 }
 
-func work(timeout time.Duration) (bool, error) {
-	// TODO: support timeout on this function
+func work(ctx context.Context, timeout time.Duration) (bool, error) {
+	return workWithClock(ctx, clock.New(), timeout)
+}
+
+// workWithClock is work, timed by clk.
+func workWithClock(ctx context.Context, clk clock.Clock, timeout time.Duration) (bool, error) {
+	// stop the worker when we stop waiting for it, however that happens
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// simulates work being done
 	ch := make(chan bool, 1)
-	go simulateWork(timeout, ch)
+	go simulateWork(ctx, clk, timeout, ch)
 
 	select {
-	case <-time.After(timeout):
+	case <-clk.After(timeout):
 		return false, errTimeout
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			// the caller's deadline is a timeout as much as ours is
+			return false, errTimeout
+		}
+		return false, ctx.Err()
 	case ok := <-ch:
 		return ok, nil
 	}
@@ -1,38 +1,102 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/jmileson/scratch/clock"
 	"github.com/stretchr/testify/assert"
 )
 
+type workResult struct {
+	ok  bool
+	err error
+}
+
+// startWork runs work in the background, once it's waiting on both the
+// worker and the timeout the clock can be moved on.
+func startWork(ctx context.Context, clk *clock.Fake, timeout time.Duration) <-chan workResult {
+	result := make(chan workResult, 1)
+	go func() {
+		ok, err := workWithClock(ctx, clk, timeout)
+		result <- workResult{ok, err}
+	}()
+
+	clk.BlockUntil(2)
+
+	return result
+}
+
 func TestWorkSucceeds(t *testing.T) {
 	assert := assert.New(t)
 
-	ok, err := work(1 * time.Second)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	result := startWork(context.Background(), clk, 1*time.Second)
+	clk.Advance(100 * time.Millisecond)
 
-	assert.True(ok)
-	assert.NoError(err)
+	res := <-result
+	assert.True(res.ok)
+	assert.NoError(res.err)
 }
 
-// TODO: get this test working
 func TestWorkFailsNormally(t *testing.T) {
 	assert := assert.New(t)
 
-	ok, err := work(1*time.Second + 1)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	result := startWork(context.Background(), clk, 1*time.Second+1)
+	clk.Advance(100 * time.Millisecond)
 
-	assert.False(ok)
-	assert.NoError(err)
+	res := <-result
+	assert.False(res.ok)
+	assert.NoError(res.err)
 }
 
-// TODO: get this test working
 // NOTE: this test ensures that the timeout is working right
 func TestWorkFailsWithTimeout(t *testing.T) {
 	assert := assert.New(t)
 
-	ok, err := work(1 * time.Nanosecond)
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	result := startWork(context.Background(), clk, 1*time.Nanosecond)
+	clk.Advance(1 * time.Nanosecond)
+
+	res := <-result
+	assert.False(res.ok)
+	assert.ErrorIs(res.err, errTimeout)
+	assert.ErrorIs(res.err, context.DeadlineExceeded)
+}
+
+func TestWorkCancelled(t *testing.T) {
+	assert := assert.New(t)
 
+	ctx, cancel := context.WithCancel(context.Background())
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	result := startWork(ctx, clk, 1*time.Second)
+	cancel()
+
+	res := <-result
+	assert.False(res.ok)
+	assert.ErrorIs(res.err, context.Canceled)
+	assert.NotErrorIs(res.err, errTimeout)
+}
+
+func TestWorkParentDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	// the fake clock never moves, so only the real deadline can end it
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ok, err := workWithClock(ctx, clk, 1*time.Second)
 	assert.False(ok)
 	assert.ErrorIs(err, errTimeout)
 }
+
+func TestWorkRealClock(t *testing.T) {
+	ok, err := work(context.Background(), 1*time.Second)
+
+	assert.True(t, ok)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Router is a ServeMux where every route runs behind a TimeoutHandler,
+// with its own timeouts or the router's defaults.
+type Router struct {
+	mux      *http.ServeMux
+	defaults []TimeoutOption
+
+	mu     sync.Mutex
+	routes map[string]timeoutConfig
+}
+
+// NewRouter creates a Router. opts apply to every route, and can be
+// overridden per route. Without WithTimeout the default is 2 seconds.
+func NewRouter(opts ...TimeoutOption) *Router {
+	return &Router{
+		mux:      http.NewServeMux(),
+		defaults: opts,
+		routes:   make(map[string]timeoutConfig),
+	}
+}
+
+// Handle registers h for pattern, as http.ServeMux does, with opts
+// applied on top of the router's defaults.
+func (rt *Router) Handle(pattern string, h http.Handler, opts ...TimeoutOption) {
+	all := make([]TimeoutOption, 0, len(rt.defaults)+len(opts))
+	all = append(all, rt.defaults...)
+	all = append(all, opts...)
+	cfg := newTimeoutConfig(all)
+
+	// ServeMux panics on duplicate patterns, so do it before recording the route
+	rt.mux.Handle(pattern, timeoutHandler(h, cfg))
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.routes[pattern] = cfg
+}
+
+// HandleFunc registers f for pattern, see Handle.
+func (rt *Router) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request), opts ...TimeoutOption) {
+	rt.Handle(pattern, http.HandlerFunc(f), opts...)
+}
+
+// ServeHTTP implements http.Handler for Router.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// RouteTimeout describes the timeouts in effect for a route.
+type RouteTimeout struct {
+	Pattern string
+
+	// Timeout is how long the handler has to finish.
+	Timeout time.Duration
+
+	// HeaderTimeout is how long the handler has to start its
+	// response, it's zero if only Timeout applies.
+	HeaderTimeout time.Duration
+}
+
+// Timeouts lists the timeouts for each route, sorted by pattern.
+func (rt *Router) Timeouts() []RouteTimeout {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	timeouts := make([]RouteTimeout, 0, len(rt.routes))
+	for pattern, cfg := range rt.routes {
+		t := RouteTimeout{Pattern: pattern, Timeout: cfg.timeout}
+		if cfg.hasHeaderTimeout() {
+			t.HeaderTimeout = cfg.headerTimeout
+		}
+		timeouts = append(timeouts, t)
+	}
+
+	sort.Slice(timeouts, func(i, j int) bool {
+		return timeouts[i].Pattern < timeouts[j].Pattern
+	})
+
+	return timeouts
+}
+
+// DebugHandler serves the route timeouts as JSON, with durations
+// written like "1.5s" so they're easy to read.
+func (rt *Router) DebugHandler() http.Handler {
+	type route struct {
+		Pattern       string `json:"pattern"`
+		Timeout       string `json:"timeout"`
+		HeaderTimeout string `json:"headerTimeout,omitempty"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeouts := rt.Timeouts()
+
+		routes := make([]route, 0, len(timeouts))
+		for _, t := range timeouts {
+			rr := route{Pattern: t.Pattern, Timeout: t.Timeout.String()}
+			if t.HeaderTimeout > 0 {
+				rr.HeaderTimeout = t.HeaderTimeout.String()
+			}
+			routes = append(routes, rr)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(routes)
+	})
+}
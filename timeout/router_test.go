@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmileson/scratch/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockUntilDone waits for the request to be cancelled.
+func blockUntilDone(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+}
+
+func serve(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestRouterPerRouteTimeouts(t *testing.T) {
+	assert := assert.New(t)
+
+	router := NewRouter(WithTimeout(time.Hour))
+	router.HandleFunc("/fast", blockUntilDone, WithTimeout(10*time.Millisecond))
+	router.HandleFunc("/deadline", func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		assert.True(ok)
+		assert.Greater(time.Until(deadline), time.Minute)
+	})
+
+	assert.Equal(http.StatusServiceUnavailable, serve(router, "/fast").Code)
+	assert.Equal(http.StatusOK, serve(router, "/deadline").Code)
+	assert.Equal(http.StatusNotFound, serve(router, "/missing").Code)
+}
+
+func TestRouterHeaderTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	clk := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	router := NewRouter(WithTimeout(time.Hour), WithHeaderTimeout(10*time.Millisecond), withClock(clk))
+	router.HandleFunc("/silent", blockUntilDone)
+	router.HandleFunc("/streaming", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		// outlive the header timeout, it no longer applies
+		clk.Sleep(30 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	// serveAfter serves path in the background, and advances
+	// the clock by d once there are n waiters.
+	serveAfter := func(path string, n int, d time.Duration) *httptest.ResponseRecorder {
+		res := make(chan *httptest.ResponseRecorder)
+		go func() {
+			res <- serve(router, path)
+		}()
+
+		clk.BlockUntil(n)
+		clk.Advance(d)
+
+		return <-res
+	}
+
+	assert.Equal(http.StatusServiceUnavailable, serveAfter("/silent", 1, 10*time.Millisecond).Code)
+
+	w := serveAfter("/streaming", 2, 30*time.Millisecond)
+	assert.Equal(http.StatusAccepted, w.Code)
+	assert.Equal("done", w.Body.String())
+}
+
+func TestRouterTimeouts(t *testing.T) {
+	assert := assert.New(t)
+
+	router := NewRouter(WithTimeout(5 * time.Second))
+	router.HandleFunc("/b", blockUntilDone)
+	router.HandleFunc("/a", blockUntilDone, WithTimeout(time.Second), WithHeaderTimeout(100*time.Millisecond))
+	// longer than the timeout, so it doesn't count
+	router.HandleFunc("/c", blockUntilDone, WithHeaderTimeout(time.Minute))
+
+	assert.Equal([]RouteTimeout{
+		{Pattern: "/a", Timeout: time.Second, HeaderTimeout: 100 * time.Millisecond},
+		{Pattern: "/b", Timeout: 5 * time.Second},
+		{Pattern: "/c", Timeout: 5 * time.Second},
+	}, router.Timeouts())
+
+	router.Handle("/debug/timeouts", router.DebugHandler())
+	w := serve(router, "/debug/timeouts")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(`[
+		{"pattern": "/a", "timeout": "1s", "headerTimeout": "100ms"},
+		{"pattern": "/b", "timeout": "5s"},
+		{"pattern": "/c", "timeout": "5s"},
+		{"pattern": "/debug/timeouts", "timeout": "5s"}
+	]`, w.Body.String())
+}
+
+func TestRouterDefaultTimeout(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc("/", blockUntilDone)
+
+	assert.Equal(t, []RouteTimeout{{Pattern: "/", Timeout: defaultTimeout}}, router.Timeouts())
+}